@@ -5,32 +5,121 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/idempotency"
 	"github.com/acoshift/wongnok/internal/management"
+	"github.com/acoshift/wongnok/internal/ratelimit"
+	"github.com/acoshift/wongnok/internal/user"
 )
 
 // API handler
 type API struct {
 	Auth       AuthService
-	Management *management.Management
+	Management ManagementService
+	User       *user.User
+
+	// InsecureCookie sends session cookie over plain http, for development only
+	InsecureCookie bool
+
+	// RateLimitStore stores rate limit buckets, nil disables rate limiting
+	RateLimitStore ratelimit.Store
+	RateLimits     []RateLimit
+
+	// TrustedProxies are proxies allowed to set X-Forwarded-For
+	TrustedProxies []*net.IPNet
+
+	// CORS allows browsers on other origins to call the api, nil disables CORS
+	CORS *CORS
+
+	// MaxBodySize limits request body in bytes for routes without their own limit,
+	// zero uses 1 MiB
+	MaxBodySize int64
+
+	// AllowUnknownFields ignores unknown fields in request body instead of rejecting them
+	AllowUnknownFields bool
+
+	// IdempotencyStore stores Idempotency-Key's responses, nil disables idempotency keys
+	IdempotencyStore idempotency.Store
+
+	// IdempotencyTTL is how long Idempotency-Key is kept, zero uses 24 hours
+	IdempotencyTTL time.Duration
+
+	// LegacyDeprecation is when unversioned routes were deprecated,
+	// zero serves them without deprecation headers
+	LegacyDeprecation time.Time
+
+	// LegacySunset is when unversioned routes start responding 410, zero never
+	LegacySunset time.Time
+
+	// Now returns current time, nil uses time.Now
+	Now func() time.Time
+}
+
+// ManagementService type
+type ManagementService interface {
+	CreateShop(ctx context.Context, shop *management.CreateShop) (shopID int64, err error)
+	GetShop(ctx context.Context, shopID int64) (*management.Shop, error)
+	EachShop(ctx context.Context, fn func(*management.Shop) error) error
+	StatShops(ctx context.Context) (*management.ShopsStat, error)
+	SearchShops(ctx context.Context, filter *management.SearchShops) (*management.SearchResult, error)
+	UpdateShop(ctx context.Context, shopID, version int64, shop *management.UpdateShop) (newVersion int64, err error)
+	DeleteShop(ctx context.Context, shopID, version int64) error
+	SetOpeningHours(ctx context.Context, shopID, version int64, timezone string, hours *management.OpeningHours) (newVersion int64, err error)
+	GetMenu(ctx context.Context, shopID int64) (*management.Menu, error)
+	SetMenu(ctx context.Context, shopID, version int64, menu *management.Menu) (newVersion int64, err error)
+	CreateTerm(ctx context.Context, term *management.CreateTerm) (termID int64, err error)
+	ListTerms(ctx context.Context, kind management.TermKind) ([]*management.Term, error)
+	UpdateTerm(ctx context.Context, kind management.TermKind, termID int64, term *management.UpdateTerm) error
+	DeleteTerm(ctx context.Context, kind management.TermKind, termID int64) error
+	SetShopTerms(ctx context.Context, shopID, version int64, terms *management.ShopTerms) (newVersion int64, err error)
+	CreateClaim(ctx context.Context, shopID, userID int64, evidence string) (claimID int64, err error)
+	ListClaims(ctx context.Context, status management.ClaimStatus) ([]*management.Claim, error)
+	ApproveClaim(ctx context.Context, claimID, reviewerID int64) error
+	RejectClaim(ctx context.Context, claimID, reviewerID int64, reason string) error
+	IsShopOwner(ctx context.Context, shopID, userID int64) (bool, error)
+	ListOwnedShops(ctx context.Context, userID int64) ([]*management.Shop, error)
+	ReplyReview(ctx context.Context, shopID, reviewID int64, reply string) error
+	DeleteReview(ctx context.Context, reviewID int64) error
 }
 
 // AuthService type
 type AuthService interface {
 	SignUp(ctx context.Context, username, password string) (userID int64, err error)
-	SignIn(ctx context.Context, username, password string) (token string, err error)
+	SignIn(ctx context.Context, username, password string) (*auth.SignInResult, error)
 	SignOut(ctx context.Context, token string) error
-	VerifyToken(ctx context.Context, token string) (userID int64, isAdmin bool, err error)
+	VerifyToken(ctx context.Context, token string) (userID int64, permissions []string, err error)
+	ListRoles(ctx context.Context) ([]*auth.Role, error)
+	SaveRole(ctx context.Context, name, description string, permissions []string) error
+	SetUserRoles(ctx context.Context, userID int64, roles []string) error
+	StartOAuth(ctx context.Context, provider string, userID int64) (authURL, nonce string, err error)
+	CompleteOAuth(ctx context.Context, provider, state, nonce, code string) (*auth.SignInResult, error)
+	ListIdentities(ctx context.Context, userID int64) ([]*auth.LinkedIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID int64, provider string) error
+	VerifyTwoFactor(ctx context.Context, challenge, code string) (token string, err error)
+	EnrollTwoFactor(ctx context.Context, userID int64) (secret, uri string, err error)
+	ConfirmTwoFactor(ctx context.Context, userID int64, code string) (recoveryCodes []string, err error)
+	DisableTwoFactor(ctx context.Context, userID int64, code string) error
+	IssueAccessToken(ctx context.Context, sessionToken string) (token string, expiresAt time.Time, err error)
+	CreateAPIKey(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (key string, apiKey *auth.APIKey, err error)
+	ListAPIKeys(ctx context.Context, userID int64) ([]*auth.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID int64) error
 }
 
 // Handler returns api's handler
 func (api API) Handler() http.Handler {
-	router := httprouter.New()
+	return api.cors(api.compress(api.rateLimit(api.fetchCredential(api.rateLimitUser(api.router())))))
+}
+
+// router registers all routes
+func (api API) router() *apiRouter {
+	router := newAPIRouter()
 
 	router.GET("/healthz", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.WriteHeader(http.StatusOK)
@@ -41,98 +130,215 @@ func (api API) Handler() http.Handler {
 		w.Write([]byte("ok"))
 	})
 
-	// auth
-	router.POST("/auth/signup", api.authSignUp)
-	router.POST("/auth/signin", api.authSignIn)
-	router.POST("/auth/signout", api.authSignOut)
+	router.GET("/openapi.json", cacheable(cachePublic)(api.openAPI))
 
-	// management
-	{
-		router := newGroupRouter(router, "/management", onlyAdminGuard)
-		router.POST("/shops", api.managementCreateShop)
-		router.GET("/shops", api.managementListShops)
+	for _, v := range api.versions() {
+		router := newGroupRouter(router, v.Prefix, api.decodeOptions(), v.middleware(api.now))
+		if v.OptionalPrecondition {
+			router.Use(optionalPrecondition)
+		}
+		v.routes(&api, router)
 	}
 
-	return api.fetchCredential(router)
+	return router
 }
 
-type groupRouter struct {
-	router     *httprouter.Router
-	prefix     string
-	middleware func(httprouter.Handle) httprouter.Handle
-}
+// routesV1 registers version 1 routes
+func (api *API) routesV1(router *groupRouter) {
+	// auth
+	{
+		router := router.Group("/auth", bodyLimit(authMaxBodySize))
+		router.POST("/signup", api.authSignUp)
+		router.POST("/signin", api.authSignIn)
+		router.POST("/signin/2fa", api.authVerifyTwoFactor)
+		router.POST("/signout", api.authSignOut)
+		router.POST("/token", api.authIssueAccessToken)
+		router.POST("/verify-email", api.authVerifyEmail)
+		router.POST("/oauth/:provider", api.authOAuthStart)
+		router.POST("/oauth/:provider/callback", api.authOAuthCallback)
+	}
 
-func (router *groupRouter) GET(path string, h httprouter.Handle) {
-	router.router.GET(router.prefix+path, router.middleware(h))
-}
+	// user
+	router.GET("/me", onlySignedInGuard(api.meGet))
+	router.PATCH("/me", onlySignedInGuard(api.meUpdate))
+	router.DELETE("/me", onlySignedInGuard(api.meDelete))
+	router.GET("/me/export", onlySignedInGuard(api.meExport))
+	router.PUT("/me/email", onlySignedInGuard(api.meSetEmail))
+	router.POST("/me/email/resend", onlySignedInGuard(api.meResendVerificationEmail))
+	router.GET("/me/identities", onlySignedInGuard(api.meListIdentities))
+	router.DELETE("/me/identities/:provider", onlySignedInGuard(api.meUnlinkIdentity))
+	router.GET("/me/api-keys", onlySignedInGuard(api.meListAPIKeys))
+	router.POST("/me/api-keys", onlySignedInGuard(api.meCreateAPIKey))
+	router.DELETE("/me/api-keys/:id", onlySignedInGuard(api.meRevokeAPIKey))
+	router.POST("/me/2fa/enroll", onlySignedInGuard(api.meEnrollTwoFactor))
+	router.POST("/me/2fa/confirm", onlySignedInGuard(api.meConfirmTwoFactor))
+	router.POST("/me/2fa/disable", onlySignedInGuard(api.meDisableTwoFactor))
 
-func (router *groupRouter) POST(path string, h httprouter.Handle) {
-	router.router.POST(router.prefix+path, router.middleware(h))
-}
+	// public reads
+	{
+		router := router.Group("", cacheable(cachePublic))
+		router.GET("/users/:username", api.userGetProfile)
+		router.GET("/shops", api.shopList)
+		router.GET("/shops/:id", api.shopGet)
+	}
+
+	// shop
+	router.POST("/shops/:id/claims", onlySignedInGuard(api.shopCreateClaim))
+
+	// management
+	managementRouter := router.Group("/management")
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionShopCreate))
+		router.POST("/shops", api.idempotent(api.managementCreateShop))
+	}
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionShopManage))
+		router.GET("/shops", cacheable(cachePrivate)(api.managementListShops))
+		router.GET("/shops/:id", api.managementGetShop)
+		router.PUT("/shops/:id", api.managementUpdateShop)
+		router.DELETE("/shops/:id", api.managementDeleteShop)
+		router.PUT("/shops/:id/terms", api.managementSetShopTerms)
+		router.PUT("/shops/:id/opening-hours", api.managementSetOpeningHours)
+		router.GET("/shops/:id/menu", api.managementGetMenu)
+		router.PUT("/shops/:id/menu", bodyLimit(menuMaxBodySize)(api.managementSetMenu))
+	}
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionTaxonomyManage))
+		for path, kind := range map[string]management.TermKind{
+			"/categories": management.TermCategory,
+			"/cuisines":   management.TermCuisine,
+			"/tags":       management.TermTag,
+		} {
+			router.POST(path, api.managementCreateTerm(kind))
+			router.GET(path, api.managementListTerms(kind))
+			router.PUT(path+"/:id", api.managementUpdateTerm(kind))
+			router.DELETE(path+"/:id", api.managementDeleteTerm(kind))
+		}
+	}
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionClaimReview))
+		router.GET("/claims", api.managementListClaims)
+		router.POST("/claims/:id/approve", api.managementApproveClaim)
+		router.POST("/claims/:id/reject", api.managementRejectClaim)
+	}
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionReviewModerate))
+		router.DELETE("/reviews/:id", api.managementDeleteReview)
+	}
+	{
+		router := managementRouter.Group("", requirePermission(auth.PermissionUserManage))
+		router.GET("/roles", api.managementListRoles)
+		router.PUT("/roles/:name", api.managementSaveRole)
+		router.PUT("/users/:id/roles", api.managementSetUserRoles)
+	}
 
-func newGroupRouter(router *httprouter.Router, prefix string, middleware func(httprouter.Handle) httprouter.Handle) *groupRouter {
-	return &groupRouter{
-		router,
-		prefix,
-		middleware,
+	// owner
+	router.GET("/owner/shops", onlySignedInGuard(api.ownerListShops))
+	{
+		router := router.Group("/owner/shops/:id", api.onlyShopOwnerGuard)
+		router.GET("", api.managementGetShop)
+		router.PUT("", api.managementUpdateShop)
+		router.PUT("/opening-hours", api.managementSetOpeningHours)
+		router.GET("/menu", api.managementGetMenu)
+		router.PUT("/menu", bodyLimit(menuMaxBodySize)(api.managementSetMenu))
+		router.POST("/reviews/:reviewID/reply", api.ownerReplyReview)
 	}
 }
 
 type ctxKey string
 
 const (
-	ctxKeyUserID  ctxKey = "user_id"
-	ctxKeyIsAdmin ctxKey = "is_admin"
-)
+	ctxKeyUserID      ctxKey = "user_id"
+	ctxKeyPermissions ctxKey = "permissions"
 
-func (api *API) fetchCredential(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			h.ServeHTTP(w, r)
-			return
-		}
+	ctxKeyBodyLimit          ctxKey = "body_limit"
+	ctxKeyAllowUnknownFields ctxKey = "allow_unknown_fields"
 
-		ctx := r.Context()
-		userID, isAdmin, err := api.Auth.VerifyToken(ctx, token)
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, err)
-			return
-		}
-		ctx = context.WithValue(ctx, ctxKeyUserID, userID)
-		ctx = context.WithValue(ctx, ctxKeyIsAdmin, isAdmin)
-		r = r.WithContext(ctx)
-		h.ServeHTTP(w, r)
-	})
-}
+	ctxKeyOptionalPrecondition ctxKey = "optional_precondition"
+)
 
 func getUserID(ctx context.Context) int64 {
 	x, _ := ctx.Value(ctxKeyUserID).(int64)
 	return x
 }
 
-func getIsAdmin(ctx context.Context) bool {
-	x, _ := ctx.Value(ctxKeyIsAdmin).(bool)
+func getPermissions(ctx context.Context) []string {
+	x, _ := ctx.Value(ctxKeyPermissions).([]string)
 	return x
 }
 
-func onlyAdminGuard(h httprouter.Handle) httprouter.Handle {
+func hasPermission(ctx context.Context, permission string) bool {
+	for _, p := range getPermissions(ctx) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission creates middleware that allows only users with given permission
+func requirePermission(permission string) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			ctx := r.Context()
+			if !hasPermission(ctx, permission) {
+				handleError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+				return
+			}
+			h(w, r, ps)
+		}
+	}
+}
+
+func onlySignedInGuard(h httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		ctx := r.Context()
-		if !getIsAdmin(ctx) {
-			handleError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+		if getUserID(ctx) == 0 {
+			handleUnauthorized(w, "", fmt.Errorf("unauthorized"))
 			return
 		}
 		h(w, r, ps)
 	}
 }
 
-func decodeJSON(r *http.Request, v interface{}) error {
-	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
-	if mt != "application/json" {
-		return fmt.Errorf("invalid content-type")
-	}
-	return json.NewDecoder(r.Body).Decode(v)
+// onlyShopOwnerGuard allows only shop managers and owners of the shop from "id" param
+func (api *API) onlyShopOwnerGuard(h httprouter.Handle) httprouter.Handle {
+	return onlySignedInGuard(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := r.Context()
+		if hasPermission(ctx, auth.PermissionShopManage) {
+			h(w, r, ps)
+			return
+		}
+
+		shopID, ok := paramID(ps, "id")
+		if !ok {
+			handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+			return
+		}
+
+		isOwner, err := api.Management.IsShopOwner(ctx, shopID, getUserID(ctx))
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !isOwner {
+			handleError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+			return
+		}
+		h(w, r, ps)
+	})
+}
+
+type successResponse struct {
+	Success bool `json:"success"`
+}
+
+type idResponse struct {
+	ID int64 `json:"id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
 }
 
 func encodeJSON(w http.ResponseWriter, v interface{}) {
@@ -147,9 +353,16 @@ func handleError(w http.ResponseWriter, statusCode int, err error) {
 		log.Println(err)
 		err = fmt.Errorf("internal error")
 	}
-	json.NewEncoder(w).Encode(struct {
-		Error string `json:"error"`
-	}{err.Error()})
+	json.NewEncoder(w).Encode(errorResponse{err.Error()})
+}
+
+// paramID parses id from router's params
+func paramID(ps httprouter.Params, name string) (int64, bool) {
+	id, err := strconv.ParseInt(ps.ByName(name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
 }
 
 func formatTime(t time.Time) string {
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
+)
+
+func TestAPI_Handler(t *testing.T) {
+	assert.NotPanics(t, func() {
+		API{}.Handler()
+	})
+}
+
+func TestOnlyShopOwnerGuard(t *testing.T) {
+	api := API{}
+
+	newRequest := func(userID int64, permissions ...string) *http.Request {
+		r := httptest.NewRequest("PUT", "/owner/shops/1", nil)
+		ctx := r.Context()
+		if userID > 0 {
+			ctx = context.WithValue(ctx, ctxKeyUserID, userID)
+		}
+		ctx = context.WithValue(ctx, ctxKeyPermissions, permissions)
+		return r.WithContext(ctx)
+	}
+	ps := httprouter.Params{{Key: "id", Value: "1"}}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		var called bool
+		h := api.onlyShopOwnerGuard(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			called = true
+		})
+
+		w := httptest.NewRecorder()
+		h(w, newRequest(0), ps)
+		assert.False(t, called)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Shop manager", func(t *testing.T) {
+		var called bool
+		h := api.onlyShopOwnerGuard(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			called = true
+		})
+
+		w := httptest.NewRecorder()
+		h(w, newRequest(1, auth.PermissionShopManage), ps)
+		assert.True(t, called)
+	})
+
+	cases := []struct {
+		Name    string
+		UserID  int64
+		Allowed bool
+	}{
+		{"Owner", 1, true},
+		{"Not owner", 2, false},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			api := API{Management: &mockManagementIsShopOwner{
+				Func: func(ctx context.Context, shopID, userID int64) (bool, error) {
+					assert.EqualValues(t, 1, shopID)
+					return userID == 1, nil
+				},
+			}}
+
+			var called bool
+			h := api.onlyShopOwnerGuard(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				called = true
+			})
+
+			w := httptest.NewRecorder()
+			h(w, newRequest(tC.UserID), ps)
+			assert.Equal(t, tC.Allowed, called)
+			if !tC.Allowed {
+				assert.EqualValues(t, http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
+
+type mockManagementIsShopOwner struct {
+	ManagementService
+
+	Func func(ctx context.Context, shopID, userID int64) (bool, error)
+}
+
+func (m *mockManagementIsShopOwner) IsShopOwner(ctx context.Context, shopID, userID int64) (bool, error) {
+	return m.Func(ctx, shopID, userID)
+}
+
+func TestRequirePermission(t *testing.T) {
+	newRequest := func(permissions ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		ctx := context.WithValue(r.Context(), ctxKeyPermissions, permissions)
+		return r.WithContext(ctx)
+	}
+
+	cases := []struct {
+		Name        string
+		Permissions []string
+		Allowed     bool
+	}{
+		{"No permission", nil, false},
+		{"Other permission", []string{auth.PermissionShopManage}, false},
+		{"Has permission", []string{auth.PermissionShopManage, auth.PermissionUserManage}, true},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			var called bool
+			h := requirePermission(auth.PermissionUserManage)(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				called = true
+			})
+
+			w := httptest.NewRecorder()
+			h(w, newRequest(tC.Permissions...), nil)
+			assert.Equal(t, tC.Allowed, called)
+			if !tC.Allowed {
+				assert.EqualValues(t, http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
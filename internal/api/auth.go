@@ -9,14 +9,16 @@ import (
 	"github.com/acoshift/wongnok/internal/validate"
 )
 
+type signUpRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
 func (api *API) authSignUp(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
+	var req signUpRequest
 	err := decodeJSON(r, &req)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, err)
+		handleError(w, decodeErrorStatus(err), err)
 		return
 	}
 
@@ -56,53 +58,224 @@ func (api *API) authSignUp(w http.ResponseWriter, r *http.Request, ps httprouter
 		return
 	}
 
-	encodeJSON(w, struct {
-		Success bool `json:"success"`
-	}{true})
+	encodeJSON(w, successResponse{true})
+}
+
+type signInRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Cookie   bool   `json:"cookie"`
 }
 
 func (api *API) authSignIn(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
+	var req signInRequest
 	err := decodeJSON(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), decodeErrorStatus(err))
 		return
 	}
 
 	ctx := r.Context()
-	token, err := api.Auth.SignIn(ctx, req.Username, req.Password)
+	result, err := api.Auth.SignIn(ctx, req.Username, req.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	encodeJSON(w, struct {
-		Success bool   `json:"success"`
-		Token   string `json:"token"`
-	}{true, token})
+	api.encodeSignInResult(w, result, req.Cookie)
 }
 
-func (api *API) authSignOut(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var req struct {
-		Token string `json:"token"`
+// signInResponse contains either token, csrf token when session is in cookie,
+// or challenge when user has to verify second factor
+type signInResponse struct {
+	Success           bool   `json:"success"`
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
+	Token             string `json:"token,omitempty"`
+	CSRFToken         string `json:"csrfToken,omitempty"`
+}
+
+// encodeSignInResult writes session token, or sets session cookie when cookie is true,
+// or challenge when user has to verify second factor
+func (api *API) encodeSignInResult(w http.ResponseWriter, result *auth.SignInResult, cookie bool) {
+	if result.Challenge != "" {
+		encodeJSON(w, signInResponse{
+			Success:           true,
+			TwoFactorRequired: true,
+			Challenge:         result.Challenge,
+		})
+		return
+	}
+
+	if cookie {
+		csrf := api.setSessionCookie(w, result.Token)
+		encodeJSON(w, signInResponse{Success: true, CSRFToken: csrf})
+		return
+	}
+
+	encodeJSON(w, signInResponse{Success: true, Token: result.Token})
+}
+
+type verifyTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+	Cookie    bool   `json:"cookie"`
+}
+
+func (api *API) authVerifyTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req verifyTwoFactorRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	token, err := api.Auth.VerifyTwoFactor(ctx, req.Challenge, req.Code)
+	if err == auth.ErrInvalidChallenge || err == auth.ErrInvalidCode {
+		handleError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err == auth.ErrTwoFactorLocked {
+		handleError(w, http.StatusTooManyRequests, err)
+		return
 	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	api.encodeSignInResult(w, &auth.SignInResult{Token: token}, req.Cookie)
+}
+
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+func (api *API) authSignOut(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req tokenRequest
 	err := decodeJSON(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), decodeErrorStatus(err))
 		return
 	}
 
+	// browser signs out session from cookie
+	fromCookie := req.Token == ""
+	if fromCookie {
+		req.Token = sessionCookie(r)
+	}
+
 	ctx := r.Context()
 	err = api.Auth.SignOut(ctx, req.Token)
+	if err == auth.ErrInvalidToken {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	encodeJSON(w, struct {
-		Success bool `json:"success"`
-	}{true})
+	if fromCookie {
+		api.clearSessionCookie(w)
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type accessTokenResponse struct {
+	Success     bool   `json:"success"`
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+// authIssueAccessToken exchanges session token for short-lived access token
+func (api *API) authIssueAccessToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req tokenRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	token, expiresAt, err := api.Auth.IssueAccessToken(ctx, req.Token)
+	if err == auth.ErrAccessTokenDisabled {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == auth.ErrInvalidToken {
+		handleError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, accessTokenResponse{true, token, formatTime(expiresAt)})
+}
+
+type urlResponse struct {
+	Success bool   `json:"success"`
+	URL     string `json:"url"`
+}
+
+// authOAuthStart returns provider's url to start sign in,
+// signed in user will link the identity to the account instead
+func (api *API) authOAuthStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	authURL, nonce, err := api.Auth.StartOAuth(ctx, ps.ByName("provider"), getUserID(ctx))
+	if err == auth.ErrProviderNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	api.setOAuthCookie(w, nonce)
+	encodeJSON(w, urlResponse{true, authURL})
+}
+
+type oauthCallbackRequest struct {
+	State  string `json:"state"`
+	Code   string `json:"code"`
+	Cookie bool   `json:"cookie"`
+}
+
+func (api *API) authOAuthCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req oauthCallbackRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	// nonce is single-use as the state
+	nonce := oauthCookie(r)
+	api.clearOAuthCookie(w)
+
+	ctx := r.Context()
+	result, err := api.Auth.CompleteOAuth(ctx, ps.ByName("provider"), req.State, nonce, req.Code)
+	if err == auth.ErrProviderNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == auth.ErrInvalidState {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == auth.ErrIdentityLinked {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	api.encodeSignInResult(w, result, req.Cookie)
 }
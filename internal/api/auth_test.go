@@ -2,12 +2,15 @@ package api
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
 )
 
 func TestAPI_authSignUp(t *testing.T) {
@@ -51,3 +54,95 @@ type mockAuthSignUp struct {
 func (m *mockAuthSignUp) SignUp(ctx context.Context, username, password string) (userID int64, err error) {
 	return m.Func(ctx, username, password)
 }
+
+type mockAuthOAuth struct {
+	AuthService
+
+	nonce string
+}
+
+func (m *mockAuthOAuth) StartOAuth(ctx context.Context, provider string, userID int64) (string, string, error) {
+	return "https://provider/authorize", "n0nce", nil
+}
+
+func (m *mockAuthOAuth) CompleteOAuth(ctx context.Context, provider, state, nonce, code string) (*auth.SignInResult, error) {
+	m.nonce = nonce
+	return nil, auth.ErrInvalidState
+}
+
+func TestAPI_authOAuth(t *testing.T) {
+	m := mockAuthOAuth{}
+	api := API{Auth: &m}
+
+	t.Run("Start sets nonce cookie", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		api.authOAuthStart(w, httptest.NewRequest("POST", "/", nil), httprouter.Params{})
+
+		assert.EqualValues(t, 200, w.Code)
+		cookies := w.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, oauthCookieName, cookies[0].Name)
+			assert.Equal(t, "n0nce", cookies[0].Value)
+			assert.True(t, cookies[0].HttpOnly)
+		}
+	})
+
+	t.Run("Callback uses nonce cookie", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"state":"s","code":"c"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(&http.Cookie{Name: oauthCookieName, Value: "n0nce"})
+		api.authOAuthCallback(w, r, httprouter.Params{})
+
+		assert.Equal(t, "n0nce", m.nonce)
+		assert.EqualValues(t, 400, w.Code)
+		if cookies := w.Result().Cookies(); assert.Len(t, cookies, 1) {
+			assert.Equal(t, -1, cookies[0].MaxAge, "expected nonce cookie is cleared")
+		}
+	})
+
+	t.Run("Callback without cookie", func(t *testing.T) {
+		m.nonce = "unset"
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"state":"s","code":"c"}`))
+		r.Header.Set("Content-Type", "application/json")
+		api.authOAuthCallback(w, r, httprouter.Params{})
+
+		assert.Equal(t, "", m.nonce)
+	})
+}
+
+type mockAuthVerifyTwoFactor struct {
+	AuthService
+
+	Err error
+}
+
+func (m *mockAuthVerifyTwoFactor) VerifyTwoFactor(ctx context.Context, challenge, code string) (string, error) {
+	return "", m.Err
+}
+
+func TestAPI_authVerifyTwoFactor(t *testing.T) {
+	cases := []struct {
+		Name string
+		Err  error
+		Code int
+	}{
+		{"Invalid code", auth.ErrInvalidCode, http.StatusUnauthorized},
+		{"Invalid challenge", auth.ErrInvalidChallenge, http.StatusUnauthorized},
+		{"Locked", auth.ErrTwoFactorLocked, http.StatusTooManyRequests},
+	}
+
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			api := API{Auth: &mockAuthVerifyTwoFactor{Err: tC.Err}}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{"challenge":"c","code":"123456"}`))
+			r.Header.Set("Content-Type", "application/json")
+			api.authVerifyTwoFactor(w, r, httprouter.Params{})
+
+			assert.EqualValues(t, tC.Code, w.Code)
+		})
+	}
+}
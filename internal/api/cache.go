@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Cache-Control policies
+const (
+	// cachePublic allows browsers and CDN to reuse public reads for a while
+	cachePublic = "public, max-age=60"
+
+	// cachePrivate makes browser revalidate every time, mostly get 304
+	cachePrivate = "private, no-cache"
+)
+
+// etagMatch reports whether If-None-Match header matches etag,
+// using weak comparison as required for GET
+func etagMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, x := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(x), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets validators and responds 304 when request's conditions match,
+// If-Modified-Since is used only when request has no If-None-Match (RFC 7232 section 6).
+// lastModified can be zero when unknown
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	match := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		match = etag != "" && etagMatch(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		match = err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	if !match {
+		return false
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// listETag creates weak etag from list's metadata,
+// so the list does not have to be loaded to validate
+func listETag(name string, count int, lastModified time.Time) string {
+	return `W/"` + name + "-" + strconv.Itoa(count) + "-" + strconv.FormatInt(lastModified.UnixNano(), 36) + `"`
+}
+
+// cacheWriter buffers successful response to hash its content,
+// passes through when handler sets its own validator or fails
+type cacheWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	wroteHeader  bool
+	passThrough  bool
+	buf          bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if code == http.StatusOK || code == http.StatusNotModified {
+		w.Header().Set("Cache-Control", w.cacheControl)
+	}
+	if code != http.StatusOK || w.Header().Get("ETag") != "" {
+		w.passThrough = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// cacheable creates middleware which sets Cache-Control on successful responses,
+// and strong etag from content hash unless handler sets its own validators
+func cacheable(cacheControl string) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				h(w, r, ps)
+				return
+			}
+
+			cw := cacheWriter{ResponseWriter: w, cacheControl: cacheControl}
+			h(&cw, r, ps)
+			if !cw.wroteHeader || cw.passThrough {
+				return
+			}
+
+			sum := sha256.Sum256(cw.buf.Bytes())
+			if notModified(w, r, `"`+hex.EncodeToString(sum[:16])+`"`, time.Time{}) {
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(cw.buf.Len()))
+			w.WriteHeader(http.StatusOK)
+			cw.buf.WriteTo(w)
+		}
+	}
+}
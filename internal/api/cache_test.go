@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETagMatch(t *testing.T) {
+	assert.True(t, etagMatch(`"a"`, `"a"`))
+	assert.True(t, etagMatch(`"b", W/"a"`, `"a"`))
+	assert.True(t, etagMatch(`"a"`, `W/"a"`))
+	assert.True(t, etagMatch(`*`, `"a"`))
+	assert.False(t, etagMatch(`"b"`, `"a"`))
+}
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2026, 10, 19, 10, 0, 0, 500, time.UTC)
+
+	cases := []struct {
+		Name     string
+		method   string
+		header   map[string]string
+		expected bool
+	}{
+		{"No condition", "GET", nil, false},
+		{"If-None-Match", "GET", map[string]string{"If-None-Match": `"v1"`}, true},
+		{"If-None-Match changed", "GET", map[string]string{"If-None-Match": `"v0"`}, false},
+		{"If-Modified-Since", "GET", map[string]string{"If-Modified-Since": "Mon, 19 Oct 2026 10:00:00 GMT"}, true},
+		{"If-Modified-Since changed", "GET", map[string]string{"If-Modified-Since": "Mon, 19 Oct 2026 09:59:59 GMT"}, false},
+		{"If-None-Match precedence", "GET", map[string]string{
+			"If-None-Match":     `"v0"`,
+			"If-Modified-Since": "Mon, 19 Oct 2026 10:00:00 GMT",
+		}, false},
+		{"POST", "POST", map[string]string{"If-None-Match": `"v1"`}, false},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			r := httptest.NewRequest(tC.method, "/", nil)
+			for k, v := range tC.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			assert.Equal(t, tC.expected, notModified(w, r, `"v1"`, lastModified))
+			assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+			assert.Equal(t, "Mon, 19 Oct 2026 10:00:00 GMT", w.Header().Get("Last-Modified"))
+			if tC.expected {
+				assert.Equal(t, http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	h := cacheable(cachePublic)(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		encodeJSON(w, successResponse{true})
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"success":true}`, w.Body.String())
+	assert.Equal(t, cachePublic, w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	t.Run("Not modified", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		h(w, r, nil)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, cachePublic, w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		h := cacheable(cachePublic)(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			handleError(w, http.StatusNotFound, errMethodNotAllowed)
+		})
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/", nil), nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("Cache-Control"))
+		assert.Empty(t, w.Header().Get("ETag"))
+	})
+
+	t.Run("Handler's validator", func(t *testing.T) {
+		h := cacheable(cachePrivate)(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			if notModified(w, r, listETag("shops", 2, time.Unix(10, 0)), time.Time{}) {
+				return
+			}
+			encodeJSON(w, successResponse{true})
+		})
+
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/", nil), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `W/"shops-2-4ldqpds"`, w.Header().Get("ETag"))
+		assert.Equal(t, cachePrivate, w.Header().Get("Cache-Control"))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+		h(w, r, nil)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, cachePrivate, w.Header().Get("Cache-Control"))
+	})
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/management"
+)
+
+// compatCases is the contract every version keeps until sunset,
+// paths are relative to version's prefix
+var compatCases = []struct {
+	Name     string
+	method   string
+	path     string
+	body     string
+	auth     AuthService
+	status   int
+	response string
+}{
+	{
+		Name:   "Sign up",
+		method: "POST", path: "/auth/signup",
+		body: `{"username":"tester","password":"123456"}`,
+		auth: &mockAuthSignUp{Func: func(ctx context.Context, username, password string) (int64, error) {
+			return 1, nil
+		}},
+		status:   http.StatusOK,
+		response: `{"success":true}`,
+	},
+	{
+		Name:   "Sign up invalid username",
+		method: "POST", path: "/auth/signup",
+		body: `{"username":"t!","password":"123456"}`,
+		auth: &mockAuthSignUp{Func: func(ctx context.Context, username, password string) (int64, error) {
+			return 0, auth.ErrUsernameInvalid
+		}},
+		status:   http.StatusBadRequest,
+		response: `{"error":"` + auth.ErrUsernameInvalid.Error() + `"}`,
+	},
+	{
+		Name:   "Sign up invalid body",
+		method: "POST", path: "/auth/signup",
+		body:     `{"username":1}`,
+		status:   http.StatusBadRequest,
+		response: `{"error":"validate: username must be string"}`,
+	},
+	{
+		Name:   "Me anonymous",
+		method: "GET", path: "/me",
+		status: http.StatusUnauthorized,
+	},
+	{
+		Name:   "Management without permission",
+		method: "GET", path: "/management/shops",
+		status:   http.StatusForbidden,
+		response: `{"error":"forbidden"}`,
+	},
+}
+
+// compatAPI serves legacy routes as deprecated at fixed time before sunset
+var compatAPI = API{
+	LegacyDeprecation: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+	LegacySunset:      time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC),
+	Now: func() time.Time {
+		return time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	},
+}
+
+// TestCompatibility runs the same contract against every version
+func TestCompatibility(t *testing.T) {
+	for _, v := range compatAPI.versions() {
+		v := v
+		t.Run(v.name(), func(t *testing.T) {
+			t.Run("Routes", func(t *testing.T) {
+				router := newAPIRouter()
+				v.routes(&API{}, newGroupRouter(router, v.Prefix, nil))
+
+				var routes []string
+				for _, r := range router.routes {
+					routes = append(routes, r.Method+" "+r.Path)
+				}
+				sort.Strings(routes)
+				b := []byte(strings.Join(routes, "\n") + "\n")
+
+				// removing or renaming route breaks shipped clients,
+				// run go test ./internal/api -update only when adding routes
+				golden := filepath.Join("testdata", "compat", v.name()+".routes")
+				if *update {
+					err := ioutil.WriteFile(golden, b, 0644)
+					assert.NoError(t, err)
+				}
+				expected, err := ioutil.ReadFile(golden)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, string(expected), string(b))
+			})
+
+			for _, tC := range compatCases {
+				tC := tC
+				t.Run(tC.Name, func(t *testing.T) {
+					r := httptest.NewRequest(tC.method, v.Prefix+tC.path, strings.NewReader(tC.body))
+					r.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					api := compatAPI
+					api.Auth = tC.auth
+					api.Handler().ServeHTTP(w, r)
+
+					assert.Equal(t, tC.status, w.Code)
+					if tC.response != "" {
+						assert.JSONEq(t, tC.response, w.Body.String())
+					}
+
+					if v.Deprecation.IsZero() {
+						assert.Empty(t, w.Header().Get("Deprecation"))
+						assert.Empty(t, w.Header().Get("Sunset"))
+						return
+					}
+					assert.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+					assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+					assert.Equal(t, `<`+v.Successor+tC.path+`>; rel="successor-version"`, w.Header().Get("Link"))
+				})
+			}
+		})
+	}
+}
+
+// mockManagementEdit records version passed to shop's edits
+type mockManagementEdit struct {
+	ManagementService
+
+	version int64
+}
+
+func (m *mockManagementEdit) UpdateShop(ctx context.Context, shopID, version int64, shop *management.UpdateShop) (int64, error) {
+	m.version = version
+	return 3, nil
+}
+
+func (m *mockManagementEdit) SetOpeningHours(ctx context.Context, shopID, version int64, timezone string, hours *management.OpeningHours) (int64, error) {
+	m.version = version
+	return 3, nil
+}
+
+// TestCompatibility_EditWithoutIfMatch keeps legacy clients which never send If-Match working
+func TestCompatibility_EditWithoutIfMatch(t *testing.T) {
+	cases := []struct {
+		Name    string
+		path    string
+		body    string
+		status  int
+		version int64
+	}{
+		{"Legacy shop", "/management/shops/1", `{"name":"Moonstore"}`, http.StatusOK, management.AnyVersion},
+		{"V1 shop", "/v1/management/shops/1", `{"name":"Moonstore"}`, http.StatusPreconditionRequired, -1},
+		{"Legacy opening hours", "/management/shops/1/opening-hours", `{"timezone":"Asia/Bangkok"}`, http.StatusOK, management.AnyVersion},
+		{"V1 opening hours", "/v1/management/shops/1/opening-hours", `{"timezone":"Asia/Bangkok"}`, http.StatusPreconditionRequired, -1},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			m := mockManagementEdit{version: -1}
+			api := compatAPI
+			api.Auth = &mockAuthVerifyToken{Func: func(ctx context.Context, token string) (int64, []string, error) {
+				return 1, []string{auth.PermissionShopManage}, nil
+			}}
+			api.Management = &m
+
+			r := httptest.NewRequest("PUT", tC.path, strings.NewReader(tC.body))
+			r.Header.Set("Authorization", "Bearer token")
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			api.Handler().ServeHTTP(w, r)
+
+			assert.Equal(t, tC.status, w.Code, w.Body.String())
+			assert.Equal(t, tC.version, m.version)
+		})
+	}
+}
+
+func TestAPIVersion_Sunset(t *testing.T) {
+	sunset := time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+	v := apiVersion{
+		Prefix:      "/v0",
+		Deprecation: sunset.AddDate(0, -6, 0),
+		Sunset:      sunset,
+		Successor:   "/v1",
+		routes:      (*API).routesV1,
+	}
+
+	cases := []struct {
+		Name   string
+		now    time.Time
+		status int
+	}{
+		{"Before sunset", sunset.Add(-time.Second), http.StatusForbidden},
+		{"At sunset", sunset, http.StatusGone},
+		{"After sunset", sunset.Add(time.Hour), http.StatusGone},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			now := func() time.Time { return tC.now }
+			router := newAPIRouter()
+			v.routes(&API{}, newGroupRouter(router, v.Prefix, v.middleware(now)))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/v0/management/shops", nil))
+			assert.Equal(t, tC.status, w.Code)
+		})
+	}
+}
+
+func TestAPI_versions(t *testing.T) {
+	for _, v := range (&API{}).versions() {
+		assert.True(t, v.Deprecation.IsZero(), "expected version %s is not deprecated without config", v.name())
+		assert.True(t, v.Sunset.IsZero())
+	}
+
+	vs := compatAPI.versions()
+	assert.Equal(t, "", vs[1].Prefix)
+	assert.Equal(t, compatAPI.LegacySunset, vs[1].Sunset)
+	assert.True(t, apiVersions[1].Sunset.IsZero(), "expected config does not change apiVersions")
+}
+
+func TestUnversionedPath(t *testing.T) {
+	assert.Equal(t, "/auth/signin", unversionedPath("/v1/auth/signin"))
+	assert.Equal(t, "/auth/signin", unversionedPath("/auth/signin"))
+	assert.Equal(t, "/v1x/shops", unversionedPath("/v1x/shops"))
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest response worth compressing,
+// smaller responses are sent as is
+const compressMinSize = 1024
+
+// Content codings
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// negotiateEncoding selects content coding from Accept-Encoding header,
+// prefers brotli when client accepts both with the same quality
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+
+		name, quality := x, 1.0
+		if p := strings.IndexByte(x, ';'); p >= 0 {
+			name = strings.TrimSpace(x[:p])
+			param := strings.TrimSpace(x[p+1:])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				quality = v
+			}
+		}
+		q[strings.ToLower(name)] = quality
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range []string{encodingBrotli, encodingGzip} {
+		v, ok := q[enc]
+		if !ok {
+			v, ok = q["*"]
+		}
+		if ok && v > bestQ {
+			best, bestQ = enc, v
+		}
+	}
+	return best
+}
+
+// compressible reports whether content type benefits from compression
+func compressible(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mt, "text/") ||
+		mt == "application/json" ||
+		strings.HasSuffix(mt, "+json") ||
+		mt == "application/javascript"
+}
+
+// compressWriter buffers response until compressMinSize to decide
+// whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	code     int
+	buf      bytes.Buffer
+
+	// decided is true when headers were sent
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.code != 0 || w.decided {
+		return
+	}
+	w.code = code
+
+	// no body or already encoded
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() >= compressMinSize {
+		w.decide(compressible(w.Header().Get("Content-Type")))
+		err := w.flushBuffer()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends headers with or without compression
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+
+		// compressed body is not byte-equal, strong etag becomes weak
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch w.encoding {
+		case encodingBrotli:
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, 5)
+		default:
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *compressWriter) flushBuffer() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Close sends buffered small response, or finishes compression
+func (w *compressWriter) Close() error {
+	if w.code == 0 {
+		// handler wrote nothing
+		return nil
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	err := w.flushBuffer()
+	if err != nil {
+		return err
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// compress compresses responses using gzip or brotli negotiated from Accept-Encoding
+func (api *API) compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// response depends on Accept-Encoding even when not compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cw := compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		h.ServeHTTP(&cw, r)
+	})
+}
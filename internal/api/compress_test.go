@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"gzip;q=0", ""},
+		{"*", "br"},
+		{"*, br;q=0", "gzip"},
+		{"GZIP", "gzip"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.header, func(t *testing.T) {
+			assert.Equal(t, tC.expected, negotiateEncoding(tC.header))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"name":"shop"}`, 200)
+
+	serve := func(method, acceptEncoding, contentType, body string, code int) *httptest.ResponseRecorder {
+		h := (&API{}).compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("ETag", `"1"`)
+			w.WriteHeader(code)
+			w.Write([]byte(body))
+		}))
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		w := serve("GET", "gzip", "application/json", large, http.StatusOK)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, `W/"1"`, w.Header().Get("ETag"))
+
+		zr, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err) {
+			b, _ := ioutil.ReadAll(zr)
+			assert.Equal(t, large, string(b))
+		}
+	})
+
+	t.Run("Brotli", func(t *testing.T) {
+		w := serve("GET", "gzip, br", "application/json", large, http.StatusOK)
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+
+		b, _ := ioutil.ReadAll(brotli.NewReader(w.Body))
+		assert.Equal(t, large, string(b))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		w := serve("GET", "gzip", "application/json", large, http.StatusInternalServerError)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	})
+
+	cases := []struct {
+		Name           string
+		method         string
+		acceptEncoding string
+		contentType    string
+		body           string
+	}{
+		{"Small", "GET", "gzip", "application/json", `{"success":true}`},
+		{"Not accepted", "GET", "", "application/json", large},
+		{"Not compressible", "GET", "gzip", "image/png", large},
+		{"HEAD", "HEAD", "gzip", "application/json", large},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			w := serve(tC.method, tC.acceptEncoding, tC.contentType, tC.body, http.StatusOK)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+			assert.Equal(t, tC.body, w.Body.String())
+		})
+	}
+}
+
+func TestJSONArrayWriter(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		enc := newJSONArrayWriter(w)
+		assert.NoError(t, enc.Close())
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("Items", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		enc := newJSONArrayWriter(w)
+		assert.False(t, enc.Started())
+		assert.NoError(t, enc.Write(idResponse{1}))
+		assert.True(t, enc.Started())
+		assert.NoError(t, enc.Write(idResponse{2}))
+		assert.NoError(t, enc.Close())
+		assert.JSONEq(t, `[{"id":1},{"id":2}]`, w.Body.String())
+	})
+}
+
+func TestHandler_Compress(t *testing.T) {
+	api := API{}
+	r := httptest.NewRequest("GET", "/openapi.json", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("ETag"), `W/"`))
+
+	zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(zr)
+		assert.Contains(t, string(b), `"openapi"`)
+	}
+}
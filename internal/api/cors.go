@@ -0,0 +1,175 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS errors
+var (
+	errCORSMethodNotAllowed = errors.New("cors: method not allowed")
+	errCORSHeaderNotAllowed = errors.New("cors: header not allowed")
+)
+
+// CORS configures cross-origin requests from browsers
+type CORS struct {
+	// AllowedOrigins are origins allowed to call the api, ex. "https://wongnok.com",
+	// "https://*.wongnok.com" allows any subdomain, "*" allows any origin
+	AllowedOrigins []string
+
+	// AllowedMethods defaults to all methods used by the api
+	AllowedMethods []string
+
+	// AllowedHeaders defaults to Authorization, Content-Type, conditional, csrf token and idempotency key headers
+	AllowedHeaders []string
+
+	// ExposedHeaders defaults to ETag, rate limit, deprecation, authentication and idempotency headers
+	ExposedHeaders []string
+
+	// AllowCredentials allows browser to send session cookie,
+	// only listed origins get credentials, never origins allowed by "*"
+	AllowCredentials bool
+
+	// MaxAge is how long browser caches preflight response
+	MaxAge time.Duration
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCORSHeaders = []string{
+		"Authorization", "Content-Type", "If-Match", "If-None-Match", csrfHeaderName, idempotencyKeyHeader,
+	}
+	defaultCORSExposed = []string{
+		"ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+		"Deprecation", "Sunset", "Link", "WWW-Authenticate", idempotencyReplayedHeader,
+	}
+)
+
+// allowOrigin reports whether origin matches any allowed origin
+func (c *CORS) allowOrigin(origin string) bool {
+	return containsFold(c.AllowedOrigins, "*") || c.listedOrigin(origin)
+}
+
+// listedOrigin reports whether origin matches allowed origin other than "*"
+func (c *CORS) listedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, x := range c.AllowedOrigins {
+		x = strings.ToLower(x)
+		if x == origin {
+			return true
+		}
+
+		// wildcard subdomain, ex. https://*.wongnok.com
+		p := strings.Index(x, "://*.")
+		if p < 0 {
+			continue
+		}
+		scheme, domain := x[:p+3], x[p+4:]
+		if !strings.HasPrefix(origin, scheme) || !strings.HasSuffix(origin, domain) {
+			continue
+		}
+		sub := origin[len(scheme) : len(origin)-len(domain)]
+		if sub != "" && !strings.ContainsAny(sub, "/:@") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(xs []string, s string) bool {
+	for _, x := range xs {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func orDefault(xs, def []string) []string {
+	if len(xs) == 0 {
+		return def
+	}
+	return xs
+}
+
+// cors handles preflight and sets CORS headers on responses,
+// must run before other middlewares so errors carry CORS headers too
+func (api *API) cors(h http.Handler) http.Handler {
+	c := api.CORS
+	if c == nil || len(c.AllowedOrigins) == 0 {
+		return h
+	}
+
+	methods := orDefault(c.AllowedMethods, defaultCORSMethods)
+	headers := orDefault(c.AllowedHeaders, defaultCORSHeaders)
+	exposed := strings.Join(orDefault(c.ExposedHeaders, defaultCORSExposed), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		preflight := r.Method == http.MethodOptions && requestMethod != ""
+
+		// response depends on origin, even when origin is not allowed
+		w.Header().Add("Vary", "Origin")
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin == "" || !c.allowOrigin(origin) {
+			if preflight {
+				// browser rejects response without allow origin
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// origin matched only "*" must not send credentials,
+		// or any site could call the api with user's cookie
+		if c.listedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if c.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", exposed)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if !containsFold(methods, requestMethod) {
+			handleError(w, http.StatusForbidden, errCORSMethodNotAllowed)
+			return
+		}
+		var requestHeaders []string
+		for _, x := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			x = strings.TrimSpace(x)
+			if x == "" {
+				continue
+			}
+			if !containsFold(headers, x) {
+				handleError(w, http.StatusForbidden, errCORSHeaderNotAllowed)
+				return
+			}
+			requestHeaders = append(requestHeaders, x)
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requestHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
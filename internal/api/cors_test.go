@@ -0,0 +1,162 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS_allowOrigin(t *testing.T) {
+	c := CORS{AllowedOrigins: []string{"https://wongnok.com", "https://*.wongnok.com"}}
+
+	cases := []struct {
+		Name    string
+		allowed bool
+	}{
+		{"https://wongnok.com", true},
+		{"https://WONGNOK.com", true},
+		{"https://www.wongnok.com", true},
+		{"https://admin.th.wongnok.com", true},
+		{"http://www.wongnok.com", false},
+		{"https://www.wongnok.com:8443", false},
+		{"https://evilwongnok.com", false},
+		{"https://wongnok.com.evil.com", false},
+		{"https://evil.com@x.wongnok.com", false},
+		{"null", false},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			assert.Equal(t, tC.allowed, c.allowOrigin(tC.Name))
+		})
+	}
+
+	assert.True(t, (&CORS{AllowedOrigins: []string{"*"}}).allowOrigin("http://localhost:3000"))
+}
+
+func TestAPI_cors(t *testing.T) {
+	api := API{CORS: &CORS{
+		AllowedOrigins:   []string{"https://*.wongnok.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}}
+	var called bool
+	h := api.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	newRequest := func(method, origin string) *http.Request {
+		r := httptest.NewRequest(method, "/v1/me", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	t.Run("Same origin", func(t *testing.T) {
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("GET", ""))
+		assert.True(t, called)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	})
+
+	t.Run("Allowed origin", func(t *testing.T) {
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("GET", "https://www.wongnok.com"))
+		assert.True(t, called)
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "https://www.wongnok.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "RateLimit-Remaining")
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	})
+
+	t.Run("Disallowed origin", func(t *testing.T) {
+		called = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("GET", "https://evil.com"))
+		assert.True(t, called)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		called = false
+		r := newRequest("OPTIONS", "https://www.wongnok.com")
+		r.Header.Set("Access-Control-Request-Method", "PATCH")
+		r.Header.Set("Access-Control-Request-Headers", "content-type, x-csrf-token")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.False(t, called)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://www.wongnok.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, HEAD, POST, PUT, PATCH, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type, x-csrf-token", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header()["Vary"])
+	})
+
+	t.Run("Preflight disallowed header", func(t *testing.T) {
+		r := newRequest("OPTIONS", "https://www.wongnok.com")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		r.Header.Set("Access-Control-Request-Headers", "X-Debug")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("Preflight disallowed origin", func(t *testing.T) {
+		called = false
+		r := newRequest("OPTIONS", "https://evil.com")
+		r.Header.Set("Access-Control-Request-Method", "DELETE")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.False(t, called)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("Any origin without credentials", func(t *testing.T) {
+		h := (&API{CORS: &CORS{AllowedOrigins: []string{"*"}}}).cors(http.NotFoundHandler())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest("GET", "http://localhost:3000"))
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	})
+}
+
+func TestAPI_cors_AnyOrigin(t *testing.T) {
+	api := API{CORS: &CORS{
+		AllowedOrigins:   []string{"*", "https://wongnok.com"},
+		AllowCredentials: true,
+	}}
+	h := api.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/v1/me", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Any origin", func(t *testing.T) {
+		w := serve("https://evil.com")
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"), "expected no credentials for any origin")
+	})
+
+	t.Run("Listed origin", func(t *testing.T) {
+		w := serve("https://wongnok.com")
+		assert.Equal(t, "https://wongnok.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	})
+}
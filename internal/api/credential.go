@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/acoshift/wongnok/internal/auth"
+)
+
+const (
+	sessionCookieName = "session"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+	oauthCookieName   = "oauth_nonce"
+
+	// sessionCookieMaxAge is how long browser keeps session cookie
+	sessionCookieMaxAge = 30 * 24 * time.Hour
+
+	// oauthCookieMaxAge is how long user has to complete provider's consent
+	oauthCookieMaxAge = 10 * time.Minute
+
+	authRealm = "wongnok"
+)
+
+// Credential errors
+var (
+	errUnsupportedScheme = errors.New("unsupported authorization scheme")
+	errMalformedBearer   = errors.New("malformed bearer token")
+	errInvalidCSRFToken  = errors.New("invalid csrf token")
+)
+
+// reB64Token is b64token from RFC 6750 section 2.1
+var reB64Token = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+
+// parseAuthorization parses bearer token from Authorization header
+func parseAuthorization(header string) (token string, err error) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 || !strings.EqualFold(header[:i], "Bearer") {
+		return "", errUnsupportedScheme
+	}
+	token = strings.TrimLeft(header[i+1:], " ")
+	if !reB64Token.MatchString(token) {
+		return "", errMalformedBearer
+	}
+	return token, nil
+}
+
+// csrfToken derives csrf token from session token,
+// only who can read session token can compute it
+func csrfToken(sessionToken string) string {
+	h := sha256.Sum256([]byte("csrf:" + sessionToken))
+	return hex.EncodeToString(h[:])
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// setSessionCookie stores session token in HttpOnly cookie,
+// and csrf token in cookie readable by front end to send back in X-CSRF-Token header
+func (api *API) setSessionCookie(w http.ResponseWriter, token string) (csrf string) {
+	csrf = csrfToken(token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionCookieMaxAge / time.Second),
+		Secure:   !api.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrf,
+		Path:     "/",
+		MaxAge:   int(sessionCookieMaxAge / time.Second),
+		Secure:   !api.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return csrf
+}
+
+func (api *API) clearSessionCookie(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   !api.InsecureCookie,
+			HttpOnly: name == sessionCookieName,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// sessionCookie returns session token from cookie
+func sessionCookie(r *http.Request) string {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// setOAuthCookie keeps oauth flow's nonce in browser started the flow
+func (api *API) setOAuthCookie(w http.ResponseWriter, nonce string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(oauthCookieMaxAge / time.Second),
+		Secure:   !api.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (api *API) clearOAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   !api.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oauthCookie returns oauth flow's nonce from cookie
+func oauthCookie(r *http.Request) string {
+	c, err := r.Cookie(oauthCookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// handleUnauthorized writes 401 with bearer challenge,
+// errorCode is omitted when request has no credential
+func handleUnauthorized(w http.ResponseWriter, errorCode string, err error) {
+	challenge := `Bearer realm="` + authRealm + `"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `", error_description="` + err.Error() + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	handleError(w, http.StatusUnauthorized, err)
+}
+
+// fetchCredential verifies bearer token from Authorization header,
+// or session cookie which requires csrf token for unsafe methods
+func (api *API) fetchCredential(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			token      string
+			fromCookie bool
+			err        error
+		)
+		if header := r.Header.Get("Authorization"); header != "" {
+			token, err = parseAuthorization(header)
+			if err != nil && err != errUnsupportedScheme {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="invalid_request"`)
+				handleError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		// other schemes are not ours (ex. basic auth of proxy in front of api),
+		// serve as no authorization header then guards respond 401 when route requires credential
+		if token == "" {
+			token = sessionCookie(r)
+			fromCookie = true
+		}
+		if token == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		userID, permissions, err := api.Auth.VerifyToken(ctx, token)
+		if err == auth.ErrInvalidToken || err == auth.ErrTokenExpired {
+			if fromCookie {
+				api.clearSessionCookie(w)
+			}
+			handleUnauthorized(w, "invalid_token", err)
+			return
+		}
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// browser sends cookie with cross-site requests, require token only our page can read
+		if fromCookie && !isSafeMethod(r.Method) {
+			got := r.Header.Get(csrfHeaderName)
+			if subtle.ConstantTimeCompare([]byte(got), []byte(csrfToken(token))) != 1 {
+				handleError(w, http.StatusForbidden, errInvalidCSRFToken)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, ctxKeyUserID, userID)
+		ctx = context.WithValue(ctx, ctxKeyPermissions, permissions)
+		r = r.WithContext(ctx)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	cases := []struct {
+		header string
+		token  string
+		err    error
+	}{
+		{"Bearer abc123", "abc123", nil},
+		{"bearer abc-._~+/123==", "abc-._~+/123==", nil},
+		{"Bearer  abc", "abc", nil},
+		{"abc123", "", errUnsupportedScheme},
+		{"Basic dXNlcjpwYXNz", "", errUnsupportedScheme},
+		{"Bearer ", "", errMalformedBearer},
+		{"Bearer abc def", "", errMalformedBearer},
+		{"Bearer a=b", "", errMalformedBearer},
+	}
+	for _, tC := range cases {
+		t.Run(tC.header, func(t *testing.T) {
+			token, err := parseAuthorization(tC.header)
+			assert.Equal(t, tC.err, err)
+			assert.Equal(t, tC.token, token)
+		})
+	}
+}
+
+type mockAuthVerifyToken struct {
+	AuthService
+
+	Func func(ctx context.Context, token string) (userID int64, permissions []string, err error)
+}
+
+func (m *mockAuthVerifyToken) VerifyToken(ctx context.Context, token string) (userID int64, permissions []string, err error) {
+	return m.Func(ctx, token)
+}
+
+func TestFetchCredential(t *testing.T) {
+	api := API{Auth: &mockAuthVerifyToken{
+		Func: func(ctx context.Context, token string) (int64, []string, error) {
+			switch token {
+			case "valid":
+				return 1, nil, nil
+			case "expired":
+				return 0, nil, auth.ErrTokenExpired
+			}
+			return 0, nil, auth.ErrInvalidToken
+		},
+	}}
+
+	var userID int64
+	h := api.fetchCredential(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = getUserID(r.Context())
+	}))
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		userID = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		w := serve(httptest.NewRequest("GET", "/", nil))
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 0, userID)
+	})
+
+	t.Run("Bearer", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Authorization", "Bearer valid")
+		w := serve(r)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 1, userID)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer unknown")
+		w := serve(r)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer expired")
+		w := serve(r)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "token expired")
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer a b")
+		w := serve(r)
+		assert.EqualValues(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_request"`)
+	})
+
+	t.Run("Unsupported scheme", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		w := serve(r)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 0, userID)
+	})
+
+	t.Run("Unsupported scheme with cookie", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "valid"})
+		w := serve(r)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 1, userID)
+	})
+
+	t.Run("Cookie safe method", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "valid"})
+		w := serve(r)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 1, userID)
+	})
+
+	t.Run("Cookie without CSRF token", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "valid"})
+		w := serve(r)
+		assert.EqualValues(t, http.StatusForbidden, w.Code)
+		assert.EqualValues(t, 0, userID)
+	})
+
+	t.Run("Cookie with CSRF token", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "valid"})
+		r.Header.Set(csrfHeaderName, csrfToken("valid"))
+		w := serve(r)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 1, userID)
+	})
+
+	t.Run("Invalid cookie", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "unknown"})
+		w := serve(r)
+		assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("Set-Cookie"), "Max-Age=0")
+	})
+}
+
+func TestAPI_Handler_UnsupportedScheme(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/me", nil)
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	API{}.Handler().ServeHTTP(w, r)
+
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code, "expected guarded route requires credential")
+	assert.Equal(t, `Bearer realm="`+authRealm+`"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestAPI_setSessionCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	csrf := (&API{}).setSessionCookie(w, "token")
+	assert.Equal(t, csrfToken("token"), csrf)
+
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, sessionCookieName, cookies[0].Name)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+		assert.Equal(t, csrfCookieName, cookies[1].Name)
+		assert.False(t, cookies[1].HttpOnly, "expected front end can read csrf token")
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// Request body limits
+const (
+	// defaultMaxBodySize is used when API's MaxBodySize is not set
+	defaultMaxBodySize = 1 << 20
+
+	// authMaxBodySize is enough for credentials
+	authMaxBodySize = 16 << 10
+
+	// menuMaxBodySize allows large menus
+	menuMaxBodySize = 4 << 20
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// decodeOptions creates middleware which applies api's body decoding config,
+// must be the outermost middleware of routes so bodyLimit can override it
+func (api *API) decodeOptions() middleware {
+	limit := api.MaxBodySize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, ctxKeyBodyLimit, limit)
+			ctx = context.WithValue(ctx, ctxKeyAllowUnknownFields, api.AllowUnknownFields)
+			h(w, r.WithContext(ctx), ps)
+		}
+	}
+}
+
+// bodyLimit creates middleware which limits request body of routes to n bytes
+func bodyLimit(n int64) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			ctx := context.WithValue(r.Context(), ctxKeyBodyLimit, n)
+			h(w, r.WithContext(ctx), ps)
+		}
+	}
+}
+
+// decodeJSON decodes request body into v, returns errBodyTooLarge
+// when body exceeds route's limit, or *validate.Error with path of invalid value
+func decodeJSON(r *http.Request, v interface{}) error {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "application/json" {
+		return fmt.Errorf("invalid content-type")
+	}
+
+	b, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	raw, err := readJSON(b)
+	if err != nil {
+		return err
+	}
+
+	// validate against the same schema as openapi document
+	allowUnknownFields, _ := r.Context().Value(ctxKeyAllowUnknownFields).(bool)
+	s := requestSchemaOf(reflect.TypeOf(v).Elem())
+	err = s.g.validateSchema(raw, s.root, "", allowUnknownFields)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, v)
+	if err, ok := err.(*json.UnmarshalTypeError); ok {
+		// schema accepts the value but go type can not hold it, ex. overflow
+		return validate.NewError(fieldPath(err.Field), "is out of range")
+	}
+	return err
+}
+
+// readBody reads request body up to route's limit
+func readBody(r *http.Request) ([]byte, error) {
+	limit, _ := r.Context().Value(ctxKeyBodyLimit).(int64)
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+
+	// read one more byte to tell body at the limit from larger body,
+	// server discards the rest of body before closing the connection
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return b, nil
+}
+
+// decodeErrorStatus returns status code for error from decodeJSON
+func decodeErrorStatus(err error) int {
+	if err == errBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// readJSON parses single json value with numbers as json.Number,
+// rejects duplicate keys and trailing data
+func readJSON(b []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, validate.NewRequiredError("body")
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	v, err := readJSONValue(d, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, validate.NewError("body", "must not have trailing data")
+	}
+	return v, nil
+}
+
+func readJSONValue(d *json.Decoder, path string) (interface{}, error) {
+	invalid := validate.NewError(fieldPath(path), "must be valid json")
+
+	t, err := d.Token()
+	if err != nil {
+		return nil, invalid
+	}
+
+	switch t {
+	case json.Delim('{'):
+		m := make(map[string]interface{})
+		for d.More() {
+			t, err := d.Token()
+			if err != nil {
+				return nil, invalid
+			}
+			k := t.(string)
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if _, ok := m[k]; ok {
+				return nil, validate.NewError(p, "is duplicated")
+			}
+			m[k], err = readJSONValue(d, p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if t, err := d.Token(); err != nil || t != json.Delim('}') {
+			return nil, invalid
+		}
+		return m, nil
+	case json.Delim('['):
+		xs := make([]interface{}, 0)
+		for i := 0; d.More(); i++ {
+			x, err := readJSONValue(d, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, x)
+		}
+		if t, err := d.Token(); err != nil || t != json.Delim(']') {
+			return nil, invalid
+		}
+		return xs, nil
+	}
+	return t, nil
+}
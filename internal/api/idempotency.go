@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/idempotency"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	// defaultIdempotencyTTL is used when API's IdempotencyTTL is not set
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 255
+)
+
+var errInvalidIdempotencyKey = errors.New("invalid idempotency key")
+
+// idempotencyReplayHeaders are response headers stored with response,
+// other headers are set by middlewares on every response
+var idempotencyReplayHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyFingerprint identifies request, the same key must be sent with the same request
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyWriter records response while writing it
+type idempotencyWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotencyWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// idempotent makes route safe to retry with Idempotency-Key header,
+// response of the first request is replayed to retries with the same key.
+// Must run after guards, requests without key are not affected
+func (api *API) idempotent(h httprouter.Handle) httprouter.Handle {
+	if api.IdempotencyStore == nil {
+		return h
+	}
+
+	ttl := api.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			h(w, r, ps)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			handleError(w, http.StatusBadRequest, errInvalidIdempotencyKey)
+			return
+		}
+
+		body, err := readBody(r)
+		if err != nil {
+			handleError(w, decodeErrorStatus(err), err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// keys are per client, clients can not see others' responses
+		ctx := r.Context()
+		scope := "ip:" + clientIP(r, api.TrustedProxies)
+		if userID := getUserID(ctx); userID > 0 {
+			scope = "user:" + strconv.FormatInt(userID, 10)
+		}
+		key = scope + "|" + key
+
+		resp, err := api.IdempotencyStore.Begin(ctx, key, idempotencyFingerprint(r, body), time.Now(), ttl)
+		if err == idempotency.ErrInProgress {
+			w.Header().Set("Retry-After", "1")
+			handleError(w, http.StatusConflict, err)
+			return
+		}
+		if err == idempotency.ErrKeyReused {
+			handleError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if resp != nil {
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(idempotencyReplayedHeader, "true")
+			w.WriteHeader(resp.StatusCode)
+			w.Write(resp.Body)
+			return
+		}
+
+		// store response even when client is gone, client will retry
+		storeCtx := context.Background()
+
+		iw := idempotencyWriter{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			err := api.IdempotencyStore.Release(storeCtx, key)
+			if err != nil {
+				log.Println("api: idempotency release error;", err)
+			}
+		}()
+		h(&iw, r, ps)
+
+		// server errors may succeed on retry
+		if iw.code == 0 || iw.code >= 500 {
+			return
+		}
+
+		header := make(http.Header)
+		for _, k := range idempotencyReplayHeaders {
+			if v := w.Header()[k]; len(v) > 0 {
+				header[k] = v
+			}
+		}
+		err = api.IdempotencyStore.Complete(storeCtx, key, &idempotency.Response{
+			StatusCode: iw.code,
+			Header:     header,
+			Body:       iw.body.Bytes(),
+		})
+		if err != nil {
+			log.Println("api: idempotency complete error;", err)
+			return
+		}
+		completed = true
+	}
+}
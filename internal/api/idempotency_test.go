@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/idempotency"
+)
+
+func TestAPI_idempotent(t *testing.T) {
+	api := API{IdempotencyStore: idempotency.NewMemoryStore()}
+
+	calls := 0
+	h := api.idempotent(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		calls++
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) == "fail" {
+			handleError(w, http.StatusInternalServerError, errMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Location", "/shops/1")
+		w.WriteHeader(http.StatusCreated)
+		encodeJSON(w, idResponse{int64(calls)})
+	})
+
+	serve := func(key, body string, userID int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/management/shops", strings.NewReader(body))
+		r.RemoteAddr = "1.2.3.4:1"
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		if userID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyUserID, userID))
+		}
+		w := httptest.NewRecorder()
+		h(w, r, nil)
+		return w
+	}
+
+	w := serve("k1", "a", 1)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	// retry replays the first response
+	w = serve("k1", "a", 1)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+	assert.Equal(t, "/shops/1", w.Header().Get("Location"))
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls)
+
+	// same key with different body
+	w = serve("k1", "b", 1)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, calls)
+
+	// keys are per user
+	w = serve("k1", "a", 2)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, calls)
+
+	// without key
+	serve("", "a", 1)
+	serve("", "a", 1)
+	assert.Equal(t, 4, calls)
+
+	// server error is not stored
+	w = serve("k2", "fail", 1)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = serve("k2", "fail", 1)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 6, calls)
+
+	// invalid key
+	w = serve(strings.Repeat("k", 256), "a", 1)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPI_idempotent_InProgress(t *testing.T) {
+	api := API{IdempotencyStore: idempotency.NewMemoryStore()}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	started := make(chan struct{})
+	h := api.idempotent(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		close(started)
+		wg.Wait()
+		encodeJSON(w, idResponse{1})
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/management/shops", strings.NewReader("a"))
+		r.Header.Set("Idempotency-Key", "k1")
+		w := httptest.NewRecorder()
+		h(w, r, nil)
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve() }()
+	<-started
+
+	w := serve()
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	wg.Done()
+	w = <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+}
@@ -1,32 +1,489 @@
 package api
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/acoshift/wongnok/internal/auth"
 	"github.com/acoshift/wongnok/internal/management"
 	"github.com/acoshift/wongnok/internal/validate"
 )
 
+type createShopRequest struct {
+	Name         string                   `json:"name"`
+	Description  string                   `json:"description"`
+	Photos       []string                 `json:"photos"`
+	Timezone     string                   `json:"timezone"`
+	OpeningHours *management.OpeningHours `json:"openingHours"`
+}
+
 func (api *API) managementCreateShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var req struct {
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Photos      []string `json:"photos"`
+	var req createShopRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	shopID, err := api.Management.CreateShop(ctx, &management.CreateShop{
+		Name:         req.Name,
+		Description:  req.Description,
+		Photos:       req.Photos,
+		Timezone:     req.Timezone,
+		OpeningHours: req.OpeningHours,
+	})
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, idResponse{shopID})
+}
+
+type managementShopItem struct {
+	ID           int64                    `json:"id"`
+	Name         string                   `json:"name"`
+	Description  string                   `json:"description"`
+	Photos       []string                 `json:"photos"`
+	Timezone     string                   `json:"timezone"`
+	OpeningHours *management.OpeningHours `json:"openingHours"`
+	CreatedAt    string                   `json:"createdAt"`
+	UpdatedAt    string                   `json:"updatedAt"`
+
+	// Version is used as If-Match to update the shop
+	Version int64 `json:"version"`
+}
+
+func newManagementShopItem(x *management.Shop) *managementShopItem {
+	return &managementShopItem{
+		ID:           x.ID,
+		Name:         x.Name,
+		Description:  x.Description,
+		Photos:       x.Photos,
+		Timezone:     x.Timezone,
+		OpeningHours: x.OpeningHours,
+		CreatedAt:    formatTime(x.CreatedAt),
+		UpdatedAt:    formatTime(x.UpdatedAt),
+		Version:      x.Version,
+	}
+}
+
+func (api *API) managementListShops(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	stat, err := api.Management.StatShops(ctx)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// shops may change after stat, client will get full response on next request
+	if notModified(w, r, listETag("shops", stat.Count, stat.LastModified), stat.LastModified) {
+		return
+	}
+
+	// stream shops while reading rows, list can be large
+	enc := newJSONArrayWriter(w)
+	err = api.Management.EachShop(ctx, func(x *management.Shop) error {
+		return enc.Write(newManagementShopItem(x))
+	})
+	if err != nil && !enc.Started() {
+		w.Header().Del("ETag")
+		w.Header().Del("Last-Modified")
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		// status already sent, abort to let client see truncated response
+		log.Println("api: list shops error;", err)
+		panic(http.ErrAbortHandler)
 	}
+	enc.Close()
+}
+
+func (api *API) managementGetShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	shop, err := api.Management.GetShop(ctx, shopID)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if notModified(w, r, shopETag(shop.Version), shop.UpdatedAt) {
+		return
+	}
+	encodeJSON(w, newManagementShopItem(shop))
+}
+
+func (api *API) managementDeleteShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+	version, ok := requireShopVersion(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	err := api.Management.DeleteShop(ctx, shopID, version)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrShopVersionMismatch {
+		api.shopPreconditionFailed(w, r, shopID)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type setShopTermsRequest struct {
+	Categories []string `json:"categories"`
+	Cuisines   []string `json:"cuisines"`
+	Tags       []string `json:"tags"`
+}
+
+func (api *API) managementSetShopTerms(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+	version, ok := requireShopVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req setShopTermsRequest
 	err := decodeJSON(r, &req)
 	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	newVersion, err := api.Management.SetShopTerms(ctx, shopID, version, &management.ShopTerms{
+		Categories: req.Categories,
+		Cuisines:   req.Cuisines,
+		Tags:       req.Tags,
+	})
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrShopVersionMismatch {
+		api.shopPreconditionFailed(w, r, shopID)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
 		handleError(w, http.StatusBadRequest, err)
 		return
 	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("ETag", shopETag(newVersion))
+	encodeJSON(w, successResponse{true})
+}
+
+type setOpeningHoursRequest struct {
+	Timezone     string                   `json:"timezone"`
+	OpeningHours *management.OpeningHours `json:"openingHours"`
+}
+
+func (api *API) managementSetOpeningHours(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+	version, ok := requireShopVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req setOpeningHoursRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
 
 	ctx := r.Context()
-	shopID, err := api.Management.CreateShop(ctx, &management.CreateShop{
+	newVersion, err := api.Management.SetOpeningHours(ctx, shopID, version, req.Timezone, req.OpeningHours)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrShopVersionMismatch {
+		api.shopPreconditionFailed(w, r, shopID)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("ETag", shopETag(newVersion))
+	encodeJSON(w, successResponse{true})
+}
+
+type termRequest struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+func (api *API) managementCreateTerm(kind management.TermKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req termRequest
+		err := decodeJSON(r, &req)
+		if err != nil {
+			handleError(w, decodeErrorStatus(err), err)
+			return
+		}
+
+		ctx := r.Context()
+		termID, err := api.Management.CreateTerm(ctx, &management.CreateTerm{
+			Kind: kind,
+			Slug: req.Slug,
+			Name: req.Name,
+		})
+		if err, ok := err.(*validate.Error); ok {
+			handleError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		encodeJSON(w, idResponse{termID})
+	}
+}
+
+type managementTermItem struct {
+	ID        int64  `json:"id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func (api *API) managementListTerms(kind management.TermKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := r.Context()
+		terms, err := api.Management.ListTerms(ctx, kind)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		list := make([]*managementTermItem, 0, len(terms))
+		for _, x := range terms {
+			list = append(list, &managementTermItem{
+				ID:        x.ID,
+				Slug:      x.Slug,
+				Name:      x.Name,
+				CreatedAt: formatTime(x.CreatedAt),
+			})
+		}
+
+		encodeJSON(w, list)
+	}
+}
+
+func (api *API) managementUpdateTerm(kind management.TermKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		termID, ok := paramID(ps, "id")
+		if !ok {
+			handleError(w, http.StatusNotFound, management.ErrTermNotFound)
+			return
+		}
+
+		var req termRequest
+		err := decodeJSON(r, &req)
+		if err != nil {
+			handleError(w, decodeErrorStatus(err), err)
+			return
+		}
+
+		ctx := r.Context()
+		err = api.Management.UpdateTerm(ctx, kind, termID, &management.UpdateTerm{
+			Slug: req.Slug,
+			Name: req.Name,
+		})
+		if err == management.ErrTermNotFound {
+			handleError(w, http.StatusNotFound, err)
+			return
+		}
+		if err, ok := err.(*validate.Error); ok {
+			handleError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		encodeJSON(w, successResponse{true})
+	}
+}
+
+func (api *API) managementDeleteTerm(kind management.TermKind) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		termID, ok := paramID(ps, "id")
+		if !ok {
+			handleError(w, http.StatusNotFound, management.ErrTermNotFound)
+			return
+		}
+
+		ctx := r.Context()
+		err := api.Management.DeleteTerm(ctx, kind, termID)
+		if err == management.ErrTermNotFound {
+			handleError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		encodeJSON(w, successResponse{true})
+	}
+}
+
+func (api *API) managementGetMenu(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	m, err := api.Management.GetMenu(ctx, shopID)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if notModified(w, r, shopETag(m.ShopVersion), time.Time{}) {
+		return
+	}
+	encodeJSON(w, newMenu(m))
+}
+
+func (api *API) managementSetMenu(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+	version, ok := requireShopVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req menu
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	newVersion, err := api.Management.SetMenu(ctx, shopID, version, req.toMenu())
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrShopVersionMismatch {
+		api.menuPreconditionFailed(w, r, shopID)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("ETag", shopETag(newVersion))
+	encodeJSON(w, successResponse{true})
+}
+
+type updateShopRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Photos      []string `json:"photos"`
+}
+
+func (api *API) managementUpdateShop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+	version, ok := requireShopVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateShopRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	newVersion, err := api.Management.UpdateShop(ctx, shopID, version, &management.UpdateShop{
 		Name:        req.Name,
 		Description: req.Description,
 		Photos:      req.Photos,
 	})
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrShopVersionMismatch {
+		api.shopPreconditionFailed(w, r, shopID)
+		return
+	}
 	if err, ok := err.(*validate.Error); ok {
 		handleError(w, http.StatusBadRequest, err)
 		return
@@ -36,36 +493,226 @@ func (api *API) managementCreateShop(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	encodeJSON(w, struct {
-		ID int64 `json:"id"`
-	}{shopID})
+	w.Header().Set("ETag", shopETag(newVersion))
+	encodeJSON(w, successResponse{true})
 }
 
-func (api *API) managementListShops(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+type claimItem struct {
+	ID           int64  `json:"id"`
+	ShopID       int64  `json:"shopId"`
+	UserID       int64  `json:"userId"`
+	Evidence     string `json:"evidence"`
+	Status       string `json:"status"`
+	RejectReason string `json:"rejectReason"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+func (api *API) managementListClaims(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	status := management.ClaimStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = management.ClaimPending
+	}
+	if !status.Valid() {
+		handleError(w, http.StatusBadRequest, validate.NewError("status", "invalid"))
+		return
+	}
+
+	ctx := r.Context()
+	claims, err := api.Management.ListClaims(ctx, status)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]*claimItem, 0, len(claims))
+	for _, x := range claims {
+		list = append(list, &claimItem{
+			ID:           x.ID,
+			ShopID:       x.ShopID,
+			UserID:       x.UserID,
+			Evidence:     x.Evidence,
+			Status:       string(x.Status),
+			RejectReason: x.RejectReason,
+			CreatedAt:    formatTime(x.CreatedAt),
+		})
+	}
+
+	encodeJSON(w, list)
+}
+
+func (api *API) managementApproveClaim(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	claimID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrClaimNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	err := api.Management.ApproveClaim(ctx, claimID, getUserID(ctx))
+	if err == management.ErrClaimNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrClaimNotPending {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type rejectClaimRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (api *API) managementRejectClaim(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	claimID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrClaimNotFound)
+		return
+	}
+
+	var req rejectClaimRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
 	ctx := r.Context()
-	shops, err := api.Management.ListShops(ctx)
+	err = api.Management.RejectClaim(ctx, claimID, getUserID(ctx), req.Reason)
+	if err == management.ErrClaimNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == management.ErrClaimNotPending {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		handleError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	type item struct {
-		ID          int64    `json:"id"`
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Photos      []string `json:"photos"`
-		CreatedAt   string   `json:"createdAt"`
+	encodeJSON(w, successResponse{true})
+}
+
+func (api *API) managementDeleteReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reviewID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrReviewNotFound)
+		return
 	}
-	list := make([]*item, 0, len(shops))
-	for _, x := range shops {
-		list = append(list, &item{
-			ID:          x.ID,
+
+	ctx := r.Context()
+	err := api.Management.DeleteReview(ctx, reviewID)
+	if err == management.ErrReviewNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type roleItem struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+func (api *API) managementListRoles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	roles, err := api.Auth.ListRoles(ctx)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]*roleItem, 0, len(roles))
+	for _, x := range roles {
+		list = append(list, &roleItem{
 			Name:        x.Name,
 			Description: x.Description,
-			Photos:      x.Photos,
+			Permissions: x.Permissions,
 			CreatedAt:   formatTime(x.CreatedAt),
 		})
 	}
 
 	encodeJSON(w, list)
 }
+
+type saveRoleRequest struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (api *API) managementSaveRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req saveRoleRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.Auth.SaveRole(ctx, ps.ByName("name"), req.Description, req.Permissions)
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type setUserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+func (api *API) managementSetUserRoles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, auth.ErrUserNotFound)
+		return
+	}
+
+	var req setUserRolesRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.Auth.SetUserRoles(ctx, userID, req.Roles)
+	if err == auth.ErrUserNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"github.com/acoshift/wongnok/internal/management"
+)
+
+type menuItem struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Price       int64                    `json:"price"`
+	Photos      []string                 `json:"photos"`
+	Available   *bool                    `json:"available"`
+	Dietary     []management.DietaryFlag `json:"dietary"`
+}
+
+type menuSection struct {
+	Name  string      `json:"name"`
+	Items []*menuItem `json:"items"`
+}
+
+type menu struct {
+	Sections []*menuSection `json:"sections"`
+}
+
+// toMenu converts request's menu to management's menu,
+// items are available if not specified
+func (m *menu) toMenu() *management.Menu {
+	var result management.Menu
+	for _, s := range m.Sections {
+		if s == nil {
+			result.Sections = append(result.Sections, nil)
+			continue
+		}
+
+		section := management.MenuSection{Name: s.Name}
+		for _, x := range s.Items {
+			if x == nil {
+				section.Items = append(section.Items, nil)
+				continue
+			}
+
+			available := true
+			if x.Available != nil {
+				available = *x.Available
+			}
+			section.Items = append(section.Items, &management.MenuItem{
+				Name:        x.Name,
+				Description: x.Description,
+				Price:       x.Price,
+				Photos:      x.Photos,
+				Available:   available,
+				Dietary:     x.Dietary,
+			})
+		}
+		result.Sections = append(result.Sections, &section)
+	}
+	return &result
+}
+
+func newMenu(x *management.Menu) *menu {
+	result := menu{Sections: make([]*menuSection, 0, len(x.Sections))}
+	for _, s := range x.Sections {
+		section := menuSection{
+			Name:  s.Name,
+			Items: make([]*menuItem, 0, len(s.Items)),
+		}
+		for _, it := range s.Items {
+			available := it.Available
+			photos := it.Photos
+			if photos == nil {
+				photos = []string{}
+			}
+			dietary := it.Dietary
+			if dietary == nil {
+				dietary = []management.DietaryFlag{}
+			}
+			section.Items = append(section.Items, &menuItem{
+				Name:        it.Name,
+				Description: it.Description,
+				Price:       it.Price,
+				Photos:      photos,
+				Available:   &available,
+				Dietary:     dietary,
+			})
+		}
+		result.Sections = append(result.Sections, &section)
+	}
+	return &result
+}
@@ -0,0 +1,674 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// securitySignedIn marks operation which requires signed in user
+const securitySignedIn = "signedIn"
+
+// operation documents a registered route
+type operation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+
+	// Security is empty for public operation, securitySignedIn,
+	// or permission required to call the operation
+	Security string
+
+	Deprecated bool
+
+	// Precondition is the current representation responded with 412,
+	// operation requires If-Match header when set
+	Precondition interface{}
+
+	// OptionalPrecondition accepts request without If-Match header
+	OptionalPrecondition bool
+
+	// Idempotent accepts Idempotency-Key header to retry safely
+	Idempotent bool
+
+	Query    []queryParam
+	Request  interface{}
+	Response interface{}
+}
+
+type queryParam struct {
+	Name        string
+	Type        string
+	Array       bool
+	Description string
+}
+
+// fileResponse documents non-json response
+type fileResponse struct {
+	ContentType string
+}
+
+// systemOperations are unversioned operations
+var systemOperations = []operation{
+	{Method: "GET", Path: "/healthz", Tag: "system", Summary: "Health check"},
+	{Method: "GET", Path: "/sleep", Tag: "system", Summary: "Respond after 10 seconds", Response: fileResponse{"text/plain"}},
+	{Method: "GET", Path: "/openapi.json", Tag: "system", Summary: "OpenAPI document", Response: fileResponse{"application/json"}},
+}
+
+// operationsV1 must cover every route in API.routesV1, see TestOpenAPI_Routes
+var operationsV1 = append([]operation{
+	// auth
+	{Method: "POST", Path: "/auth/signup", Tag: "auth", Summary: "Sign up",
+		Request: signUpRequest{}, Response: successResponse{}},
+	{Method: "POST", Path: "/auth/signin", Tag: "auth", Summary: "Sign in, may require second factor",
+		Request: signInRequest{}, Response: signInResponse{}},
+	{Method: "POST", Path: "/auth/signin/2fa", Tag: "auth", Summary: "Complete sign in with second factor",
+		Request: verifyTwoFactorRequest{}, Response: signInResponse{}},
+	{Method: "POST", Path: "/auth/signout", Tag: "auth", Summary: "Sign out token, or session cookie when token is empty",
+		Request: tokenRequest{}, Response: successResponse{}},
+	{Method: "POST", Path: "/auth/token", Tag: "auth", Summary: "Exchange session token for access token",
+		Request: tokenRequest{}, Response: accessTokenResponse{}},
+	{Method: "POST", Path: "/auth/verify-email", Tag: "auth", Summary: "Verify email address",
+		Request: tokenRequest{}, Response: successResponse{}},
+	{Method: "POST", Path: "/auth/oauth/:provider", Tag: "auth", Summary: "Start OAuth sign in, or link identity when signed in",
+		Response: urlResponse{}},
+	{Method: "POST", Path: "/auth/oauth/:provider/callback", Tag: "auth", Summary: "Complete OAuth sign in",
+		Request: oauthCallbackRequest{}, Response: signInResponse{}},
+
+	// user
+	{Method: "GET", Path: "/me", Tag: "user", Summary: "Get current user", Security: securitySignedIn,
+		Response: meResponse{}},
+	{Method: "PATCH", Path: "/me", Tag: "user", Summary: "Update profile", Security: securitySignedIn,
+		Request: updateProfileRequest{}, Response: successResponse{}},
+	{Method: "DELETE", Path: "/me", Tag: "user", Summary: "Delete account", Security: securitySignedIn,
+		Response: successResponse{}},
+	{Method: "GET", Path: "/me/export", Tag: "user", Summary: "Export personal data", Security: securitySignedIn,
+		Response: fileResponse{"application/zip"}},
+	{Method: "PUT", Path: "/me/email", Tag: "user", Summary: "Set email and send verification", Security: securitySignedIn,
+		Request: setEmailRequest{}, Response: successResponse{}},
+	{Method: "POST", Path: "/me/email/resend", Tag: "user", Summary: "Resend verification email", Security: securitySignedIn,
+		Response: successResponse{}},
+	{Method: "GET", Path: "/me/identities", Tag: "user", Summary: "List linked identities", Security: securitySignedIn,
+		Response: []*identityItem{}},
+	{Method: "DELETE", Path: "/me/identities/:provider", Tag: "user", Summary: "Unlink identity", Security: securitySignedIn,
+		Response: successResponse{}},
+	{Method: "GET", Path: "/me/api-keys", Tag: "user", Summary: "List api keys", Security: securitySignedIn,
+		Response: []*apiKeyItem{}},
+	{Method: "POST", Path: "/me/api-keys", Tag: "user", Summary: "Create api key, the key is returned only once", Security: securitySignedIn,
+		Request: createAPIKeyRequest{}, Response: createAPIKeyResponse{}},
+	{Method: "DELETE", Path: "/me/api-keys/:id", Tag: "user", Summary: "Revoke api key", Security: securitySignedIn,
+		Response: successResponse{}},
+	{Method: "POST", Path: "/me/2fa/enroll", Tag: "user", Summary: "Enroll two-factor", Security: securitySignedIn,
+		Response: enrollTwoFactorResponse{}},
+	{Method: "POST", Path: "/me/2fa/confirm", Tag: "user", Summary: "Confirm two-factor and get recovery codes", Security: securitySignedIn,
+		Request: codeRequest{}, Response: recoveryCodesResponse{}},
+	{Method: "POST", Path: "/me/2fa/disable", Tag: "user", Summary: "Disable two-factor", Security: securitySignedIn,
+		Request: codeRequest{}, Response: successResponse{}},
+	{Method: "GET", Path: "/users/:username", Tag: "user", Summary: "Get public profile",
+		Response: publicProfileResponse{}},
+
+	// shop
+	{Method: "GET", Path: "/shops", Tag: "shop", Summary: "Search shops",
+		Query: []queryParam{
+			{Name: "category", Type: "string", Array: true, Description: "category slug"},
+			{Name: "cuisine", Type: "string", Array: true, Description: "cuisine slug"},
+			{Name: "tag", Type: "string", Array: true, Description: "tag slug"},
+			{Name: "openNow", Type: "boolean", Description: "only shops open now"},
+		},
+		Response: shopListResponse{}},
+	{Method: "GET", Path: "/shops/:id", Tag: "shop", Summary: "Get shop with menu",
+		Response: shopDetailResponse{}},
+	{Method: "POST", Path: "/shops/:id/claims", Tag: "shop", Summary: "Claim shop ownership", Security: securitySignedIn,
+		Request: createClaimRequest{}, Response: idResponse{}},
+
+	// management
+	{Method: "POST", Path: "/management/shops", Tag: "management", Summary: "Create shop", Security: auth.PermissionShopCreate,
+		Request: createShopRequest{}, Response: idResponse{}, Idempotent: true},
+	{Method: "GET", Path: "/management/shops", Tag: "management", Summary: "List shops", Security: auth.PermissionShopManage,
+		Response: []*managementShopItem{}},
+	{Method: "GET", Path: "/management/shops/:id", Tag: "management", Summary: "Get shop, ETag is shop's version", Security: auth.PermissionShopManage,
+		Response: managementShopItem{}},
+	{Method: "PUT", Path: "/management/shops/:id", Tag: "management", Summary: "Update shop", Security: auth.PermissionShopManage,
+		Request: updateShopRequest{}, Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "DELETE", Path: "/management/shops/:id", Tag: "management", Summary: "Delete shop", Security: auth.PermissionShopManage,
+		Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "PUT", Path: "/management/shops/:id/terms", Tag: "management", Summary: "Set shop's categories, cuisines and tags", Security: auth.PermissionShopManage,
+		Request: setShopTermsRequest{}, Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "PUT", Path: "/management/shops/:id/opening-hours", Tag: "management", Summary: "Set opening hours", Security: auth.PermissionShopManage,
+		Request: setOpeningHoursRequest{}, Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "GET", Path: "/management/shops/:id/menu", Tag: "management", Summary: "Get menu", Security: auth.PermissionShopManage,
+		Response: menu{}},
+	{Method: "PUT", Path: "/management/shops/:id/menu", Tag: "management", Summary: "Replace menu", Security: auth.PermissionShopManage,
+		Request: menu{}, Response: successResponse{}, Precondition: menu{}},
+	{Method: "GET", Path: "/management/claims", Tag: "management", Summary: "List claims", Security: auth.PermissionClaimReview,
+		Query: []queryParam{
+			{Name: "status", Type: "string", Description: "pending, approved or rejected"},
+		},
+		Response: []*claimItem{}},
+	{Method: "POST", Path: "/management/claims/:id/approve", Tag: "management", Summary: "Approve claim", Security: auth.PermissionClaimReview,
+		Response: successResponse{}},
+	{Method: "POST", Path: "/management/claims/:id/reject", Tag: "management", Summary: "Reject claim", Security: auth.PermissionClaimReview,
+		Request: rejectClaimRequest{}, Response: successResponse{}},
+	{Method: "DELETE", Path: "/management/reviews/:id", Tag: "management", Summary: "Delete review", Security: auth.PermissionReviewModerate,
+		Response: successResponse{}},
+	{Method: "GET", Path: "/management/roles", Tag: "management", Summary: "List roles", Security: auth.PermissionUserManage,
+		Response: []*roleItem{}},
+	{Method: "PUT", Path: "/management/roles/:name", Tag: "management", Summary: "Create or update role", Security: auth.PermissionUserManage,
+		Request: saveRoleRequest{}, Response: successResponse{}},
+	{Method: "PUT", Path: "/management/users/:id/roles", Tag: "management", Summary: "Set user's roles", Security: auth.PermissionUserManage,
+		Request: setUserRolesRequest{}, Response: successResponse{}},
+
+	// owner
+	{Method: "GET", Path: "/owner/shops", Tag: "owner", Summary: "List owned shops", Security: securitySignedIn,
+		Response: []*shopItem{}},
+	{Method: "GET", Path: "/owner/shops/:id", Tag: "owner", Summary: "Get owned shop, ETag is shop's version", Security: securitySignedIn,
+		Response: managementShopItem{}},
+	{Method: "PUT", Path: "/owner/shops/:id", Tag: "owner", Summary: "Update owned shop", Security: securitySignedIn,
+		Request: updateShopRequest{}, Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "PUT", Path: "/owner/shops/:id/opening-hours", Tag: "owner", Summary: "Set owned shop's opening hours", Security: securitySignedIn,
+		Request: setOpeningHoursRequest{}, Response: successResponse{}, Precondition: managementShopItem{}},
+	{Method: "GET", Path: "/owner/shops/:id/menu", Tag: "owner", Summary: "Get owned shop's menu", Security: securitySignedIn,
+		Response: menu{}},
+	{Method: "PUT", Path: "/owner/shops/:id/menu", Tag: "owner", Summary: "Replace owned shop's menu", Security: securitySignedIn,
+		Request: menu{}, Response: successResponse{}, Precondition: menu{}},
+	{Method: "POST", Path: "/owner/shops/:id/reviews/:reviewID/reply", Tag: "owner", Summary: "Reply review", Security: securitySignedIn,
+		Request: replyReviewRequest{}, Response: successResponse{}},
+}, taxonomyOperations()...)
+
+func taxonomyOperations() []operation {
+	var ops []operation
+	for _, x := range []struct{ path, name string }{
+		{"/management/categories", "category"},
+		{"/management/cuisines", "cuisine"},
+		{"/management/tags", "tag"},
+	} {
+		ops = append(ops,
+			operation{Method: "POST", Path: x.path, Tag: "management", Summary: "Create " + x.name, Security: auth.PermissionTaxonomyManage,
+				Request: termRequest{}, Response: idResponse{}},
+			operation{Method: "GET", Path: x.path, Tag: "management", Summary: "List " + x.name, Security: auth.PermissionTaxonomyManage,
+				Response: []*managementTermItem{}},
+			operation{Method: "PUT", Path: x.path + "/:id", Tag: "management", Summary: "Update " + x.name, Security: auth.PermissionTaxonomyManage,
+				Request: termRequest{}, Response: successResponse{}},
+			operation{Method: "DELETE", Path: x.path + "/:id", Tag: "management", Summary: "Delete " + x.name, Security: auth.PermissionTaxonomyManage,
+				Response: successResponse{}},
+		)
+	}
+	return ops
+}
+
+// schema is a subset of OpenAPI 3.0 schema object
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+const componentPrefix = "#/components/schemas/"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGenerator generates schema from go type the same way encoding/json encodes it,
+// named structs are generated into components
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{components: make(map[string]*schema)}
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaOf(t.Elem())
+		if s.Ref != "" {
+			// siblings of $ref are ignored
+			return &schema{AllOf: []*schema{s}, Nullable: true}
+		}
+		c := *s
+		c.Nullable = true
+		return &c
+	case reflect.Slice:
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Array:
+		n := t.Len()
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem()), MinItems: &n, MaxItems: &n}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem()), Nullable: true}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.Struct:
+		if t == timeType {
+			return &schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// reserve name before generate, struct may refer to itself
+			g.components[name] = nil
+			g.components[name] = g.structSchema(t)
+		}
+		return &schema{Ref: componentPrefix + name}
+	}
+	// interface, any value
+	return &schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := schema{Type: "object", Properties: make(map[string]*schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if p := strings.Index(tag, ","); p >= 0 {
+			name, opts = tag[:p], tag[p+1:]
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			// encoding/json flattens embedded struct's fields
+			for k, v := range g.structSchema(ft).Properties {
+				if _, ok := s.Properties[k]; !ok {
+					s.Properties[k] = v
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if opts == "string" {
+			s.Properties[name] = &schema{Type: "string"}
+			continue
+		}
+		s.Properties[name] = g.schemaOf(f.Type)
+	}
+	return &s
+}
+
+// componentName returns exported name of t,
+// types outside api package are prefixed with package name
+func componentName(t reflect.Type) string {
+	name := upperFirst(t.Name())
+	if pkg := t.PkgPath(); pkg != reflect.TypeOf(API{}).PkgPath() {
+		name = upperFirst(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	return name
+}
+
+func upperFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// resolve returns schema which s refers to
+func (g *schemaGenerator) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		s = g.components[strings.TrimPrefix(s.Ref, componentPrefix)]
+	}
+	return s
+}
+
+func jsonContent(s *schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": s},
+	}
+}
+
+// openAPIPath converts httprouter's path to OpenAPI path
+func openAPIPath(path string) (p string, params []string) {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// allOperations returns system operations and operations of every version
+func allOperations() []operation {
+	ops := append([]operation(nil), systemOperations...)
+	for _, v := range apiVersions {
+		for _, op := range v.operations {
+			op.Path = v.Prefix + op.Path
+			op.Deprecated = v.Successor != ""
+			op.OptionalPrecondition = v.OptionalPrecondition
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// buildOpenAPI builds OpenAPI document from operations
+func buildOpenAPI() map[string]interface{} {
+	g := newSchemaGenerator()
+	errorSchema := g.schemaOf(reflect.TypeOf(errorResponse{}))
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range allOperations() {
+		path, pathParams := openAPIPath(op.Path)
+
+		var params []interface{}
+		for _, name := range pathParams {
+			typ := "string"
+			if name == "id" || strings.HasSuffix(name, "ID") {
+				typ = "integer"
+			}
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   &schema{Type: typ},
+			})
+		}
+		if op.Precondition != nil {
+			params = append(params, map[string]interface{}{
+				"name":        "If-Match",
+				"in":          "header",
+				"required":    !op.OptionalPrecondition,
+				"description": "shop's version from ETag",
+				"schema":      &schema{Type: "string"},
+			})
+		}
+		if op.Idempotent {
+			params = append(params, map[string]interface{}{
+				"name":        idempotencyKeyHeader,
+				"in":          "header",
+				"description": "unique key up to 255 characters to retry the request safely, retries respond the first response",
+				"schema":      &schema{Type: "string"},
+			})
+		}
+		for _, q := range op.Query {
+			s := &schema{Type: q.Type}
+			if q.Array {
+				s = &schema{Type: "array", Items: s}
+			}
+			params = append(params, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      s,
+			})
+		}
+
+		ok := map[string]interface{}{"description": "OK"}
+		switch resp := op.Response.(type) {
+		case nil:
+		case fileResponse:
+			ok["content"] = map[string]interface{}{
+				resp.ContentType: map[string]interface{}{
+					"schema": &schema{Type: "string", Format: "binary"},
+				},
+			}
+		default:
+			ok["content"] = jsonContent(g.schemaOf(reflect.TypeOf(resp)))
+		}
+
+		responses := map[string]interface{}{
+			"200": ok,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     jsonContent(errorSchema),
+			},
+		}
+		if op.Idempotent {
+			responses["409"] = map[string]interface{}{
+				"description": "Request with the same key is in progress",
+				"content":     jsonContent(errorSchema),
+			}
+			responses["422"] = map[string]interface{}{
+				"description": "Key was used with different request",
+				"content":     jsonContent(errorSchema),
+			}
+		}
+		if op.Precondition != nil {
+			responses["412"] = map[string]interface{}{
+				"description": "Version mismatch, responds current representation",
+				"content":     jsonContent(g.schemaOf(reflect.TypeOf(op.Precondition))),
+			}
+			if !op.OptionalPrecondition {
+				responses["428"] = map[string]interface{}{
+					"description": "If-Match required",
+					"content":     jsonContent(errorSchema),
+				}
+			}
+		}
+
+		o := map[string]interface{}{
+			"tags":      []string{op.Tag},
+			"summary":   op.Summary,
+			"responses": responses,
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Deprecated {
+			o["deprecated"] = true
+		}
+		if op.Request != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(g.schemaOf(reflect.TypeOf(op.Request))),
+			}
+			responses["413"] = map[string]interface{}{
+				"description": "Request body too large",
+				"content":     jsonContent(errorSchema),
+			}
+		}
+		if op.Security != "" {
+			o["security"] = []map[string][]string{
+				{"bearerAuth": {}},
+				{"cookieAuth": {}},
+			}
+			if op.Security != securitySignedIn {
+				o["x-permission"] = op.Security
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.Method)] = o
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Wongnok API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "session token, access token or api key",
+				},
+				"cookieAuth": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        sessionCookieName,
+					"description": "unsafe methods require X-CSRF-Token header",
+				},
+			},
+		},
+	}
+}
+
+var openAPIDocument struct {
+	once sync.Once
+	b    []byte
+}
+
+func (api *API) openAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	openAPIDocument.once.Do(func() {
+		openAPIDocument.b, _ = json.Marshal(buildOpenAPI())
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPIDocument.b)
+}
+
+// requestSchema is generated schema for request type
+type requestSchema struct {
+	g    *schemaGenerator
+	root *schema
+}
+
+var requestSchemas sync.Map // map[reflect.Type]*requestSchema
+
+func requestSchemaOf(t reflect.Type) *requestSchema {
+	if s, ok := requestSchemas.Load(t); ok {
+		return s.(*requestSchema)
+	}
+	g := newSchemaGenerator()
+	s := &requestSchema{g, g.schemaOf(t)}
+	requestSchemas.Store(t, s)
+	return s
+}
+
+// validateSchema validates decoded json value against schema,
+// numbers must be decoded as json.Number.
+// Unknown object's fields are rejected unless allowUnknownFields
+func (g *schemaGenerator) validateSchema(v interface{}, s *schema, path string, allowUnknownFields bool) error {
+	s = g.resolve(s)
+	if v == nil {
+		// encoding/json leaves the value unchanged on null,
+		// clients have been sending null for empty fields
+		return nil
+	}
+	for _, x := range s.AllOf {
+		err := g.validateSchema(v, x, path, allowUnknownFields)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch s.Type {
+	case "string":
+		x, ok := v.(string)
+		if !ok {
+			return validate.NewError(fieldPath(path), "must be string")
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, x); err != nil {
+				return validate.NewError(fieldPath(path), "must be date-time")
+			}
+		}
+	case "integer":
+		x, ok := v.(json.Number)
+		if !ok {
+			return validate.NewError(fieldPath(path), "must be integer")
+		}
+		if _, err := x.Int64(); err != nil {
+			return validate.NewError(fieldPath(path), "must be integer")
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return validate.NewError(fieldPath(path), "must be number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return validate.NewError(fieldPath(path), "must be boolean")
+		}
+	case "array":
+		xs, ok := v.([]interface{})
+		if !ok {
+			return validate.NewError(fieldPath(path), "must be array")
+		}
+		if s.MinItems != nil && len(xs) < *s.MinItems || s.MaxItems != nil && len(xs) > *s.MaxItems {
+			return validate.NewError(fieldPath(path), fmt.Sprintf("must have %d items", *s.MinItems))
+		}
+		for i, x := range xs {
+			err := g.validateSchema(x, s.Items, fmt.Sprintf("%s[%d]", path, i), allowUnknownFields)
+			if err != nil {
+				return err
+			}
+		}
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return validate.NewError(fieldPath(path), "must be object")
+		}
+
+		// sort keys to report the same error for the same body
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			ps := s.Properties[k]
+			if ps == nil {
+				ps = s.AdditionalProperties
+			}
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if ps == nil {
+				if allowUnknownFields {
+					// encoding/json ignores unknown fields
+					continue
+				}
+				return validate.NewError(p, "is not allowed")
+			}
+			err := g.validateSchema(m[k], ps, p, allowUnknownFields)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/management"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestOpenAPI_Routes(t *testing.T) {
+	documented := make(map[Route]bool)
+	for _, op := range allOperations() {
+		documented[Route{op.Method, op.Path}] = true
+	}
+
+	registered := make(map[Route]bool)
+	for _, r := range (API{}).router().routes {
+		registered[r] = true
+		assert.True(t, documented[r], "route %s %s is not documented", r.Method, r.Path)
+	}
+	for r := range documented {
+		assert.True(t, registered[r], "documented route %s %s is not registered", r.Method, r.Path)
+	}
+}
+
+// TestOpenAPI_Document fails when the document changes, see TestOpenAPI_Responses for handlers,
+// run go test ./internal/api -update after intended changes
+func TestOpenAPI_Document(t *testing.T) {
+	b, err := json.MarshalIndent(buildOpenAPI(), "", "  ")
+	if !assert.NoError(t, err) {
+		return
+	}
+	b = append(b, '\n')
+
+	golden := filepath.Join("testdata", "openapi.json")
+	if *update {
+		err = ioutil.WriteFile(golden, b, 0644)
+		assert.NoError(t, err)
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, string(expected), string(b), "document changed, run go test ./internal/api -update")
+}
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(body string, v interface{}) error {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return decodeJSON(r, v)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		var req menu
+		err := decode(`{"sections":[{"name":"Noodle","items":[{"name":"Tom Yum","price":80,"available":null}]}]}`, &req)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 80, req.Sections[0].Items[0].Price)
+	})
+
+	cases := []struct {
+		Name  string
+		body  string
+		v     interface{}
+		field string
+		msg   string
+	}{
+		{"Root", `[]`, &signInRequest{}, "body", "must be object"},
+		{"String", `{"username":1}`, &signInRequest{}, "username", "must be string"},
+		{"Boolean", `{"cookie":"true"}`, &signInRequest{}, "cookie", "must be boolean"},
+		{"Integer", `{"sections":[{"items":[{},{"price":1.5}]}]}`, &menu{}, "sections[0].items[1].price", "must be integer"},
+		{"Array", `{"sections":{}}`, &menu{}, "sections", "must be array"},
+		{"Date time", `{"name":"ci","expiresAt":"tomorrow"}`, &createAPIKeyRequest{}, "expiresAt", "must be date-time"},
+		{"Empty", ` `, &signInRequest{}, "body", "required"},
+		{"Syntax", `{"username":"a",}`, &signInRequest{}, "body", "must be valid json"},
+		{"Syntax nested", `{"sections":[{"items":[tru]}]}`, &menu{}, "sections[0].items[0]", "must be valid json"},
+		{"Truncated", `{"username":"a"`, &signInRequest{}, "body", "must be valid json"},
+		{"Trailing", `{"username":"a"}{}`, &signInRequest{}, "body", "must not have trailing data"},
+		{"Trailing garbage", `{"username":"a"} x`, &signInRequest{}, "body", "must not have trailing data"},
+		{"Duplicate", `{"username":"a","username":"b"}`, &signInRequest{}, "username", "is duplicated"},
+		{"Duplicate nested", `{"sections":[{"name":"a","name":"b"}]}`, &menu{}, "sections[0].name", "is duplicated"},
+		{"Unknown", `{"username":"a","admin":true}`, &signInRequest{}, "admin", "is not allowed"},
+		{"Unknown nested", `{"sections":[{"items":[{"cost":1}]}]}`, &menu{}, "sections[0].items[0].cost", "is not allowed"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			err := decode(tC.body, tC.v)
+			if assert.IsType(t, &validate.Error{}, err) {
+				assert.Equal(t, validate.NewError(tC.field, tC.msg), err)
+			}
+		})
+	}
+
+	t.Run("Null", func(t *testing.T) {
+		req := signInRequest{Username: "tester"}
+		err := decode(`{"username":null,"password":"1234"}`, &req)
+		assert.NoError(t, err, "expected null is accepted as before")
+		assert.Equal(t, "tester", req.Username)
+		assert.Equal(t, "1234", req.Password)
+	})
+}
+
+func TestDecodeJSON_Options(t *testing.T) {
+	decode := func(ctx context.Context, body string) error {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		var req signInRequest
+		return decodeJSON(r, &req)
+	}
+
+	t.Run("Allow unknown fields", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), ctxKeyAllowUnknownFields, true)
+		assert.NoError(t, decode(ctx, `{"username":"a","admin":true}`))
+	})
+
+	t.Run("Body limit", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), ctxKeyBodyLimit, int64(16))
+		assert.NoError(t, decode(ctx, `{"username":"a"}`))
+		assert.Equal(t, errBodyTooLarge, decode(ctx, `{"username":"ab"}`))
+	})
+
+	t.Run("Default limit", func(t *testing.T) {
+		body := `{"username":"` + strings.Repeat("a", defaultMaxBodySize) + `"}`
+		assert.Equal(t, errBodyTooLarge, decode(context.Background(), body))
+	})
+}
+
+func TestHandler_BodyLimit(t *testing.T) {
+	api := API{}
+	body := `{"username":"` + strings.Repeat("a", authMaxBodySize) + `"}`
+	r := httptest.NewRequest("POST", "/v1/auth/signup", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.Handler().ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"error":"request body too large"}`, w.Body.String())
+}
+
+type mockManagementContract struct {
+	ManagementService
+
+	shop *management.Shop
+	menu *management.Menu
+}
+
+func (m *mockManagementContract) GetShop(ctx context.Context, shopID int64) (*management.Shop, error) {
+	return m.shop, nil
+}
+
+func (m *mockManagementContract) GetMenu(ctx context.Context, shopID int64) (*management.Menu, error) {
+	return m.menu, nil
+}
+
+func (m *mockManagementContract) SearchShops(ctx context.Context, filter *management.SearchShops) (*management.SearchResult, error) {
+	facet := []*management.Facet{{Slug: "thai", Name: "Thai", Count: 1}}
+	return &management.SearchResult{
+		Shops:  []*management.Shop{m.shop},
+		Facets: &management.Facets{Categories: facet, Cuisines: facet, Tags: facet},
+	}, nil
+}
+
+// TestOpenAPI_Responses fails when handler's response does not match the document
+func TestOpenAPI_Responses(t *testing.T) {
+	now := time.Now()
+	term := &management.Term{ID: 1, Kind: management.TermCuisine, Slug: "thai", Name: "Thai", CreatedAt: now}
+	api := API{
+		Auth: &mockAuthVerifyToken{Func: func(ctx context.Context, token string) (int64, []string, error) {
+			return 1, []string{auth.PermissionShopManage}, nil
+		}},
+		Management: &mockManagementContract{
+			shop: &management.Shop{
+				ID: 1, Name: "Moonstore", Photos: []string{"a.jpg"},
+				CreatedAt: now, UpdatedAt: now, Version: 2,
+				Terms:        []*management.Term{term},
+				Timezone:     "Asia/Bangkok",
+				OpeningHours: &management.OpeningHours{},
+			},
+			menu: &management.Menu{
+				Sections: []*management.MenuSection{{ID: 1, Name: "Noodle", Items: []*management.MenuItem{
+					{ID: 1, Name: "Tom Yum", Price: 8000, Photos: []string{}, Available: true},
+				}}},
+				ShopVersion: 2,
+			},
+		},
+	}
+	h := api.Handler()
+
+	ops := make(map[Route]operation)
+	for _, op := range allOperations() {
+		ops[Route{op.Method, op.Path}] = op
+	}
+
+	cases := []struct {
+		Name  string
+		route Route
+		path  string
+	}{
+		{"Shop list", Route{"GET", "/v1/shops"}, "/v1/shops"},
+		{"Shop detail", Route{"GET", "/v1/shops/:id"}, "/v1/shops/1"},
+		{"Management shop", Route{"GET", "/v1/management/shops/:id"}, "/v1/management/shops/1"},
+		{"Management menu", Route{"GET", "/v1/management/shops/:id/menu"}, "/v1/management/shops/1/menu"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			op, ok := ops[tC.route]
+			if !assert.True(t, ok, "route is not documented") {
+				return
+			}
+
+			r := httptest.NewRequest(tC.route.Method, tC.path, nil)
+			r.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+				return
+			}
+
+			raw, err := readJSON(w.Body.Bytes())
+			if !assert.NoError(t, err) {
+				return
+			}
+			g := newSchemaGenerator()
+			s := g.schemaOf(reflect.TypeOf(op.Response))
+			assert.NoError(t, g.validateSchema(raw, s, "", false), "response has undocumented or mistyped field")
+			assertDocumentedFields(t, g, raw, s, "")
+		})
+	}
+}
+
+// assertDocumentedFields asserts every documented property is in response
+func assertDocumentedFields(t *testing.T, g *schemaGenerator, v interface{}, s *schema, path string) {
+	t.Helper()
+
+	s = g.resolve(s)
+	for _, x := range s.AllOf {
+		assertDocumentedFields(t, g, v, x, path)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, p := range s.Properties {
+			fp := name
+			if path != "" {
+				fp = path + "." + name
+			}
+			x, ok := v[name]
+			if !assert.True(t, ok, "documented field %s is missing", fp) {
+				continue
+			}
+			assertDocumentedFields(t, g, x, p, fp)
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return
+		}
+		for i, x := range v {
+			assertDocumentedFields(t, g, x, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
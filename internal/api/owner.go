@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/management"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+func (api *API) ownerListShops(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	shops, err := api.Management.ListOwnedShops(ctx, getUserID(ctx))
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	list := make([]*shopItem, 0, len(shops))
+	for _, x := range shops {
+		list = append(list, newShopItem(x, now))
+	}
+
+	encodeJSON(w, list)
+}
+
+type replyReviewRequest struct {
+	Reply string `json:"reply"`
+}
+
+func (api *API) ownerReplyReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, _ := paramID(ps, "id")
+	reviewID, ok := paramID(ps, "reviewID")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrReviewNotFound)
+		return
+	}
+
+	var req replyReviewRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.Management.ReplyReview(ctx, shopID, reviewID, req.Reply)
+	if err == management.ErrReviewNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
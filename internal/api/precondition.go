@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/management"
+)
+
+var errPreconditionRequired = errors.New("if-match header required")
+
+// shopETag returns strong etag of shop's version
+func shopETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion parses shop's version from If-Match header,
+// ok is false when header is missing. Unknown etag returns version
+// which never matches. Weak etags are accepted since compression weakens
+// the etag while version still identifies the shop's state
+func ifMatchVersion(r *http.Request) (version int64, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, false
+	}
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimPrefix(strings.TrimSpace(x), "W/")
+		if len(x) < 2 || x[0] != '"' || x[len(x)-1] != '"' {
+			continue
+		}
+		v, err := strconv.ParseInt(x[1:len(x)-1], 10, 64)
+		if err == nil && v > 0 {
+			return v, true
+		}
+	}
+	return -1, true
+}
+
+// optionalPrecondition lets routes update without If-Match header
+func optionalPrecondition(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := context.WithValue(r.Context(), ctxKeyOptionalPrecondition, true)
+		h(w, r.WithContext(ctx), ps)
+	}
+}
+
+// requireShopVersion returns shop's version from If-Match header,
+// or responds 428 when header is missing.
+// Routes with optionalPrecondition get management.AnyVersion instead
+func requireShopVersion(w http.ResponseWriter, r *http.Request) (version int64, ok bool) {
+	version, ok = ifMatchVersion(r)
+	if !ok {
+		if optional, _ := r.Context().Value(ctxKeyOptionalPrecondition).(bool); optional {
+			return management.AnyVersion, true
+		}
+		handleError(w, http.StatusPreconditionRequired, errPreconditionRequired)
+	}
+	return version, ok
+}
+
+func encodePreconditionFailed(w http.ResponseWriter, version int64, v interface{}) {
+	w.Header().Set("ETag", shopETag(version))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(v)
+}
+
+// shopPreconditionFailed responds 412 with shop's current representation
+func (api *API) shopPreconditionFailed(w http.ResponseWriter, r *http.Request, shopID int64) {
+	shop, err := api.Management.GetShop(r.Context(), shopID)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	encodePreconditionFailed(w, shop.Version, newManagementShopItem(shop))
+}
+
+// menuPreconditionFailed responds 412 with shop's current menu
+func (api *API) menuPreconditionFailed(w http.ResponseWriter, r *http.Request, shopID int64) {
+	m, err := api.Management.GetMenu(r.Context(), shopID)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	encodePreconditionFailed(w, m.ShopVersion, newMenu(m))
+}
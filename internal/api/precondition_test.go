@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	cases := []struct {
+		header  string
+		version int64
+		ok      bool
+	}{
+		{"", 0, false},
+		{`"3"`, 3, true},
+		{`"x", "4"`, 4, true},
+		{`W/"3"`, 3, true},
+		{`*`, -1, true},
+		{`3`, -1, true},
+		{`"0"`, -1, true},
+	}
+	for _, tC := range cases {
+		r := httptest.NewRequest("PUT", "/", nil)
+		if tC.header != "" {
+			r.Header.Set("If-Match", tC.header)
+		}
+		version, ok := ifMatchVersion(r)
+		assert.Equal(t, tC.version, version, tC.header)
+		assert.Equal(t, tC.ok, ok, tC.header)
+	}
+}
+
+func TestPreconditionRequired(t *testing.T) {
+	api := API{}
+	ps := httprouter.Params{{Key: "id", Value: "1"}}
+
+	for _, h := range []httprouter.Handle{
+		api.managementUpdateShop,
+		api.managementDeleteShop,
+		api.managementSetShopTerms,
+		api.managementSetOpeningHours,
+		api.managementSetMenu,
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("PUT", "/", nil), ps)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+		assert.JSONEq(t, `{"error":"if-match header required"}`, w.Body.String())
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acoshift/wongnok/internal/ratelimit"
+)
+
+var errTooManyRequests = errors.New("too many requests")
+
+// RateLimit limits requests to paths start with Prefix,
+// each prefix has its own bucket per client.
+// Prefix does not contain version, every version shares the bucket
+type RateLimit struct {
+	Prefix string
+	Rate   ratelimit.Rate
+}
+
+// ValidateRateLimits returns error of the first invalid rate
+func ValidateRateLimits(limits []RateLimit) error {
+	for _, l := range limits {
+		err := l.Rate.Validate()
+		if err != nil {
+			return fmt.Errorf("api: rate limit %s; %w", l.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// rateLimitFor returns the limit with longest matching prefix
+func rateLimitFor(limits []RateLimit, path string) *RateLimit {
+	var r *RateLimit
+	for i := range limits {
+		l := &limits[i]
+		if strings.HasPrefix(path, l.Prefix) && (r == nil || len(l.Prefix) > len(r.Prefix)) {
+			r = l
+		}
+	}
+	return r
+}
+
+// clientIP returns request's client ip, X-Forwarded-For is used only when
+// request comes from trusted proxy, then the right-most untrusted address is the client
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	isTrusted := func(s string) bool {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return false
+		}
+		for _, n := range trustedProxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if !isTrusted(host) {
+		return host
+	}
+
+	var forwarded []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, s := range strings.Split(h, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(s))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !isTrusted(forwarded[i]) {
+			if net.ParseIP(forwarded[i]) == nil {
+				break
+			}
+			return forwarded[i]
+		}
+	}
+	return host
+}
+
+// credentialIPFactor loosens ip's limit of requests with credential,
+// signed in users behind the same ip do not share their budget,
+// while guessing tokens is still limited before token lookup
+const credentialIPFactor = 10
+
+// hasCredential reports whether request sends token or session cookie,
+// authorization with unsupported scheme is not our credential
+func hasCredential(r *http.Request) bool {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if _, err := parseAuthorization(header); err != errUnsupportedScheme {
+			return true
+		}
+	}
+	return sessionCookie(r) != ""
+}
+
+// rateLimit limits requests by client ip, must run before fetchCredential
+// so requests with invalid credential are limited before token lookup.
+// Requests with credential take from separate bucket credentialIPFactor times larger
+func (api *API) rateLimit(h http.Handler) http.Handler {
+	return api.limitRequests(h, func(r *http.Request) (string, int) {
+		ip := clientIP(r, api.TrustedProxies)
+		if hasCredential(r) {
+			return "credential-ip:" + ip, credentialIPFactor
+		}
+		return "ip:" + ip, 1
+	})
+}
+
+// rateLimitUser limits signed in user's requests by user id,
+// must run after fetchCredential. Requests without credential are limited by rateLimit only
+func (api *API) rateLimitUser(h http.Handler) http.Handler {
+	return api.limitRequests(h, func(r *http.Request) (string, int) {
+		if userID := getUserID(r.Context()); userID > 0 {
+			return "user:" + strconv.FormatInt(userID, 10), 1
+		}
+		return "", 0
+	})
+}
+
+// limitRequests takes from bucket of key returned from keyOf,
+// bucket's limit is multiplied by factor. Empty key is not limited.
+// Store errors fail open
+func (api *API) limitRequests(h http.Handler, keyOf func(r *http.Request) (key string, factor int)) http.Handler {
+	if api.RateLimitStore == nil || len(api.RateLimits) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := rateLimitFor(api.RateLimits, unversionedPath(r.URL.Path))
+		if limit == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key, factor := keyOf(r)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rate := limit.Rate
+		rate.Limit *= factor
+
+		ctx := r.Context()
+		result, err := api.RateLimitStore.Take(ctx, limit.Prefix+"|"+key, rate, time.Now())
+		if err != nil {
+			log.Println("api: rate limit error;", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			handleError(w, http.StatusTooManyRequests, errTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	cases := []struct {
+		Name       string
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{
+		{"Direct", "1.2.3.4:1234", nil, "1.2.3.4"},
+		{"Untrusted proxy", "1.2.3.4:1234", []string{"5.6.7.8"}, "1.2.3.4"},
+		{"Trusted proxy", "10.0.0.1:1234", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"Spoofed header", "10.0.0.1:1234", []string{"9.9.9.9, 5.6.7.8"}, "5.6.7.8"},
+		{"Proxy chain", "10.0.0.1:1234", []string{"5.6.7.8, 10.0.0.2"}, "5.6.7.8"},
+		{"Multiple headers", "10.0.0.1:1234", []string{"9.9.9.9", "5.6.7.8"}, "5.6.7.8"},
+		{"Invalid header", "10.0.0.1:1234", []string{"garbage"}, "10.0.0.1"},
+		{"No header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tC.remoteAddr
+			for _, h := range tC.forwarded {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			assert.Equal(t, tC.ip, clientIP(r, trusted))
+		})
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	limits := []RateLimit{
+		{Prefix: "/", Rate: ratelimit.Rate{Limit: 1}},
+		{Prefix: "/auth/", Rate: ratelimit.Rate{Limit: 2}},
+	}
+	assert.Equal(t, 2, rateLimitFor(limits, "/auth/signin").Rate.Limit)
+	assert.Equal(t, 1, rateLimitFor(limits, "/shops").Rate.Limit)
+	assert.Nil(t, rateLimitFor(limits[1:], "/shops"))
+}
+
+func TestAPI_rateLimit(t *testing.T) {
+	api := API{
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: []RateLimit{
+			{Prefix: "/auth/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+		},
+	}
+	h := api.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/auth/signin", "1.2.3.4:1")
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = serve("/auth/signin", "1.2.3.4:2")
+	assert.EqualValues(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// other client
+	w = serve("/auth/signin", "5.6.7.8:1")
+	assert.EqualValues(t, http.StatusOK, w.Code)
+
+	// path without limit
+	w = serve("/shops", "1.2.3.4:1")
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestAPI_rateLimitUser(t *testing.T) {
+	api := API{
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: []RateLimit{
+			{Prefix: "/auth/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+		},
+	}
+	h := api.rateLimitUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(userID int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/auth/signin", nil)
+		if userID > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyUserID, userID))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.EqualValues(t, http.StatusOK, serve(1).Code)
+	assert.EqualValues(t, http.StatusTooManyRequests, serve(1).Code)
+	assert.EqualValues(t, http.StatusOK, serve(2).Code, "expected each user has own bucket")
+
+	// limited by ip before credential lookup
+	w := serve(0)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestAPI_Handler_RateLimitBeforeCredential(t *testing.T) {
+	verified := 0
+	api := API{
+		Auth: &mockAuthVerifyToken{Func: func(ctx context.Context, token string) (int64, []string, error) {
+			verified++
+			return 0, nil, auth.ErrInvalidToken
+		}},
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: []RateLimit{
+			{Prefix: "/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+		},
+	}
+	h := api.Handler()
+
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/me", nil)
+		r.RemoteAddr = "1.2.3.4:1"
+		r.Header.Set("Authorization", "Bearer guess")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < credentialIPFactor; i++ {
+		assert.EqualValues(t, http.StatusUnauthorized, serve().Code)
+	}
+	assert.EqualValues(t, http.StatusTooManyRequests, serve().Code)
+	assert.Equal(t, credentialIPFactor, verified, "expected limited request does not lookup token")
+}
+
+func TestAPI_Handler_RateLimitSharedIP(t *testing.T) {
+	api := API{
+		Auth: &mockAuthVerifyToken{Func: func(ctx context.Context, token string) (int64, []string, error) {
+			userID, _ := strconv.ParseInt(token, 10, 64)
+			return userID, nil, nil
+		}},
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: []RateLimit{
+			{Prefix: "/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+		},
+	}
+	h := api.Handler()
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.RemoteAddr = "1.2.3.4:1"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.EqualValues(t, http.StatusOK, serve("").Code)
+	assert.EqualValues(t, http.StatusTooManyRequests, serve("").Code)
+
+	// signed in users behind the same ip have their own budget
+	assert.EqualValues(t, http.StatusOK, serve("1").Code)
+	assert.EqualValues(t, http.StatusOK, serve("2").Code)
+	assert.EqualValues(t, http.StatusTooManyRequests, serve("1").Code)
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	assert.NoError(t, ValidateRateLimits([]RateLimit{
+		{Prefix: "/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+	}))
+	assert.Error(t, ValidateRateLimits([]RateLimit{
+		{Prefix: "/", Rate: ratelimit.Rate{Limit: 1, Per: time.Minute}},
+		{Prefix: "/auth/", Rate: ratelimit.Rate{Per: time.Minute}},
+	}))
+}
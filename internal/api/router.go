@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// Route is a registered route
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Routes returns all registered routes in registration order, for docs and metrics
+func (api API) Routes() []Route {
+	return api.router().routes
+}
+
+// middleware wraps handle
+type middleware func(httprouter.Handle) httprouter.Handle
+
+// chain composes middlewares into one, the first middleware is the outermost,
+// nil middlewares are skipped
+func chain(middlewares ...middleware) middleware {
+	return func(h httprouter.Handle) httprouter.Handle {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			if middlewares[i] != nil {
+				h = middlewares[i](h)
+			}
+		}
+		return h
+	}
+}
+
+// apiRouter is httprouter's router which records registered routes,
+// responds 405 and OPTIONS with sorted Allow header
+type apiRouter struct {
+	*httprouter.Router
+	routes []Route
+}
+
+func newAPIRouter() *apiRouter {
+	router := apiRouter{Router: httprouter.New()}
+
+	// httprouter answers OPTIONS without calling any handler,
+	// disable it to let methodNotAllowed answer OPTIONS
+	router.HandleOPTIONS = false
+	router.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
+	return &router
+}
+
+// methodNotAllowed responds allowed methods which set by httprouter in Allow header
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	allow := strings.Split(w.Header().Get("Allow"), ", ")
+	sort.Strings(allow)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	handleError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+}
+
+// Handle registers handle for method and path
+func (router *apiRouter) Handle(method, path string, h httprouter.Handle) {
+	router.routes = append(router.routes, Route{method, path})
+	router.Router.Handle(method, path, h)
+}
+
+func (router *apiRouter) GET(path string, h httprouter.Handle) {
+	router.Handle(http.MethodGet, path, h)
+}
+
+func (router *apiRouter) HEAD(path string, h httprouter.Handle) {
+	router.Handle(http.MethodHead, path, h)
+}
+
+func (router *apiRouter) OPTIONS(path string, h httprouter.Handle) {
+	router.Handle(http.MethodOptions, path, h)
+}
+
+func (router *apiRouter) POST(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPost, path, h)
+}
+
+func (router *apiRouter) PUT(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPut, path, h)
+}
+
+func (router *apiRouter) PATCH(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPatch, path, h)
+}
+
+func (router *apiRouter) DELETE(path string, h httprouter.Handle) {
+	router.Handle(http.MethodDelete, path, h)
+}
+
+// groupRouter registers routes under prefix with middleware stack
+type groupRouter struct {
+	router      *apiRouter
+	prefix      string
+	middlewares []middleware
+
+	// only skips routes it does not allow, nil allows all routes
+	only func(method, path string) bool
+}
+
+func newGroupRouter(router *apiRouter, prefix string, middlewares ...middleware) *groupRouter {
+	return &groupRouter{
+		router:      router,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// Group creates sub group which inherits prefix and middleware stack,
+// parent's middlewares run first
+func (router *groupRouter) Group(prefix string, middlewares ...middleware) *groupRouter {
+	stack := make([]middleware, 0, len(router.middlewares)+len(middlewares))
+	stack = append(stack, router.middlewares...)
+	stack = append(stack, middlewares...)
+	g := newGroupRouter(router.router, router.prefix+prefix, stack...)
+	g.only = router.only
+	return g
+}
+
+// Use appends middlewares to the stack,
+// routes registered before Use are not affected
+func (router *groupRouter) Use(middlewares ...middleware) {
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+// Handle registers handle wrapped with middleware stack for method and path
+func (router *groupRouter) Handle(method, path string, h httprouter.Handle) {
+	if router.only != nil && !router.only(method, router.prefix+path) {
+		return
+	}
+	router.router.Handle(method, router.prefix+path, chain(router.middlewares...)(h))
+}
+
+func (router *groupRouter) GET(path string, h httprouter.Handle) {
+	router.Handle(http.MethodGet, path, h)
+}
+
+func (router *groupRouter) HEAD(path string, h httprouter.Handle) {
+	router.Handle(http.MethodHead, path, h)
+}
+
+func (router *groupRouter) OPTIONS(path string, h httprouter.Handle) {
+	router.Handle(http.MethodOptions, path, h)
+}
+
+func (router *groupRouter) POST(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPost, path, h)
+}
+
+func (router *groupRouter) PUT(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPut, path, h)
+}
+
+func (router *groupRouter) PATCH(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPatch, path, h)
+}
+
+func (router *groupRouter) DELETE(path string, h httprouter.Handle) {
+	router.Handle(http.MethodDelete, path, h)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupRouter(t *testing.T) {
+	var calls []string
+	trace := func(name string) middleware {
+		return func(h httprouter.Handle) httprouter.Handle {
+			return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				calls = append(calls, name)
+				h(w, r, ps)
+			}
+		}
+	}
+	handler := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		calls = append(calls, "handler:"+ps.ByName("id"))
+	}
+
+	router := newAPIRouter()
+	v1 := newGroupRouter(router, "/v1", trace("v1"), nil)
+	v1.GET("/shops", handler)
+	{
+		router := v1.Group("/shops/:id", trace("shop"))
+		router.Use(trace("owner"))
+		router.PUT("", handler)
+		router.PATCH("", handler)
+		router.DELETE("", handler)
+		router.HEAD("", handler)
+		router.Group("/menu", trace("menu")).GET("", handler)
+	}
+	v1.Use(trace("late"))
+	v1.POST("/shops", handler)
+
+	assert.Equal(t, []Route{
+		{"GET", "/v1/shops"},
+		{"PUT", "/v1/shops/:id"},
+		{"PATCH", "/v1/shops/:id"},
+		{"DELETE", "/v1/shops/:id"},
+		{"HEAD", "/v1/shops/:id"},
+		{"GET", "/v1/shops/:id/menu"},
+		{"POST", "/v1/shops"},
+	}, router.routes)
+
+	cases := []struct {
+		method string
+		path   string
+		calls  []string
+	}{
+		{"GET", "/v1/shops", []string{"v1", "handler:"}},
+		{"POST", "/v1/shops", []string{"v1", "late", "handler:"}},
+		{"PATCH", "/v1/shops/1", []string{"v1", "shop", "owner", "handler:1"}},
+		{"HEAD", "/v1/shops/1", []string{"v1", "shop", "owner", "handler:1"}},
+		{"GET", "/v1/shops/2/menu", []string{"v1", "shop", "owner", "menu", "handler:2"}},
+	}
+	for _, tC := range cases {
+		t.Run(tC.method+" "+tC.path, func(t *testing.T) {
+			calls = nil
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tC.method, tC.path, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tC.calls, calls)
+		})
+	}
+}
+
+func TestAPIRouter_MethodNotAllowed(t *testing.T) {
+	router := newAPIRouter()
+	for _, method := range []string{"PUT", "GET", "DELETE", "PATCH"} {
+		router.Handle(method, "/shops/:id", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {})
+	}
+
+	t.Run("405", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/shops/1", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "DELETE, GET, OPTIONS, PATCH, PUT", w.Header().Get("Allow"))
+		assert.JSONEq(t, `{"error":"method not allowed"}`, w.Body.String())
+	})
+
+	t.Run("OPTIONS", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/shops/1", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "DELETE, GET, OPTIONS, PATCH, PUT", w.Header().Get("Allow"))
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/menus/1", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestAPI_Routes(t *testing.T) {
+	routes := API{}.Routes()
+	assert.Contains(t, routes, Route{"GET", "/healthz"})
+	assert.Contains(t, routes, Route{"PUT", "/v1/owner/shops/:id"})
+	assert.Contains(t, routes, Route{"PUT", "/owner/shops/:id"})
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/management"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+type termItem struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+func termItems(terms []*management.Term) []*termItem {
+	list := make([]*termItem, 0, len(terms))
+	for _, x := range terms {
+		list = append(list, &termItem{
+			Slug: x.Slug,
+			Name: x.Name,
+		})
+	}
+	return list
+}
+
+type facetItem struct {
+	Slug  string `json:"slug"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func facetItems(facets []*management.Facet) []*facetItem {
+	list := make([]*facetItem, 0, len(facets))
+	for _, x := range facets {
+		list = append(list, &facetItem{
+			Slug:  x.Slug,
+			Name:  x.Name,
+			Count: x.Count,
+		})
+	}
+	return list
+}
+
+type facetsItem struct {
+	Categories []*facetItem `json:"categories"`
+	Cuisines   []*facetItem `json:"cuisines"`
+	Tags       []*facetItem `json:"tags"`
+}
+
+type shopItem struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Photos      []string    `json:"photos"`
+	Categories  []*termItem `json:"categories"`
+	Cuisines    []*termItem `json:"cuisines"`
+	Tags        []*termItem `json:"tags"`
+	CreatedAt   string      `json:"createdAt"`
+
+	Timezone     string                   `json:"timezone"`
+	OpeningHours *management.OpeningHours `json:"openingHours"`
+	OpenNow      bool                     `json:"openNow"`
+}
+
+func newShopItem(x *management.Shop, now time.Time) *shopItem {
+	openNow, err := x.IsOpenAt(now)
+	if err != nil {
+		log.Printf("api: shop %d timezone error; %v", x.ID, err)
+	}
+
+	return &shopItem{
+		ID:          x.ID,
+		Name:        x.Name,
+		Description: x.Description,
+		Photos:      x.Photos,
+		Categories:  termItems(x.TermsOf(management.TermCategory)),
+		Cuisines:    termItems(x.TermsOf(management.TermCuisine)),
+		Tags:        termItems(x.TermsOf(management.TermTag)),
+		CreatedAt:   formatTime(x.CreatedAt),
+
+		Timezone:     x.Timezone,
+		OpeningHours: x.OpeningHours,
+		OpenNow:      openNow,
+	}
+}
+
+type shopListResponse struct {
+	Items  []*shopItem `json:"items"`
+	Facets *facetsItem `json:"facets"`
+}
+
+func (api *API) shopList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q := r.URL.Query()
+	now := time.Now()
+
+	filter := management.SearchShops{
+		Categories: q["category"],
+		Cuisines:   q["cuisine"],
+		Tags:       q["tag"],
+	}
+	if v := q.Get("openNow"); v != "" {
+		openNow, err := strconv.ParseBool(v)
+		if err != nil {
+			handleError(w, http.StatusBadRequest, validate.NewError("openNow", "invalid"))
+			return
+		}
+		if openNow {
+			filter.OpenAt = now
+		}
+	}
+
+	ctx := r.Context()
+	result, err := api.Management.SearchShops(ctx, &filter)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]*shopItem, 0, len(result.Shops))
+	for _, x := range result.Shops {
+		list = append(list, newShopItem(x, now))
+	}
+
+	encodeJSON(w, shopListResponse{
+		Items: list,
+		Facets: &facetsItem{
+			Categories: facetItems(result.Facets.Categories),
+			Cuisines:   facetItems(result.Facets.Cuisines),
+			Tags:       facetItems(result.Facets.Tags),
+		},
+	})
+}
+
+type shopDetailResponse struct {
+	*shopItem
+	Menu *menu `json:"menu"`
+}
+
+func (api *API) shopGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	shop, err := api.Management.GetShop(ctx, shopID)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	m, err := api.Management.GetMenu(ctx, shopID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, shopDetailResponse{newShopItem(shop, time.Now()), newMenu(m)})
+}
+
+type createClaimRequest struct {
+	Evidence string `json:"evidence"`
+}
+
+func (api *API) shopCreateClaim(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shopID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, management.ErrShopNotFound)
+		return
+	}
+
+	var req createClaimRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	claimID, err := api.Management.CreateClaim(ctx, shopID, getUserID(ctx), req.Evidence)
+	if err == management.ErrShopNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, idResponse{claimID})
+}
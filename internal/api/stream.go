@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// jsonArrayWriter streams json array item by item,
+// for lists too large to build in memory
+type jsonArrayWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// newJSONArrayWriter sets json content type, the array starts on first Write
+func newJSONArrayWriter(w http.ResponseWriter) *jsonArrayWriter {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return &jsonArrayWriter{w: w}
+}
+
+// Write appends v to the array
+func (enc *jsonArrayWriter) Write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sep := []byte(",")
+	if !enc.started {
+		enc.started = true
+		sep = []byte("[")
+	}
+	_, err = enc.w.Write(sep)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(b)
+	return err
+}
+
+// Started reports whether any item was written,
+// after that the response can not be changed to an error
+func (enc *jsonArrayWriter) Started() bool {
+	return enc.started
+}
+
+// Close ends the array, empty array when nothing was written
+func (enc *jsonArrayWriter) Close() error {
+	end := "]\n"
+	if !enc.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(enc.w, end)
+	return err
+}
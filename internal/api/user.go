@@ -0,0 +1,497 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/user"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+type meResponse struct {
+	ID            int64    `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"emailVerified"`
+	DisplayName   string   `json:"displayName"`
+	AvatarURL     string   `json:"avatarUrl"`
+	Bio           string   `json:"bio"`
+	Permissions   []string `json:"permissions"`
+	CreatedAt     string   `json:"createdAt"`
+}
+
+func (api *API) meGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	profile, err := api.User.GetProfile(ctx, getUserID(ctx))
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	permissions := getPermissions(ctx)
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	encodeJSON(w, meResponse{
+		ID:            profile.ID,
+		Username:      profile.Username,
+		Email:         profile.Email,
+		EmailVerified: profile.EmailVerified,
+		DisplayName:   profile.DisplayName,
+		AvatarURL:     profile.AvatarURL,
+		Bio:           profile.Bio,
+		Permissions:   permissions,
+		CreatedAt:     formatTime(profile.CreatedAt),
+	})
+}
+
+type updateProfileRequest struct {
+	DisplayName *string `json:"displayName"`
+	AvatarURL   *string `json:"avatarUrl"`
+	Bio         *string `json:"bio"`
+}
+
+func (api *API) meUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req updateProfileRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.User.UpdateProfile(ctx, getUserID(ctx), &user.UpdateProfile{
+		DisplayName: req.DisplayName,
+		AvatarURL:   req.AvatarURL,
+		Bio:         req.Bio,
+	})
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type setEmailRequest struct {
+	Email string `json:"email"`
+}
+
+func (api *API) meSetEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req setEmailRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.User.SetEmail(ctx, getUserID(ctx), req.Email)
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+func (api *API) meResendVerificationEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	err := api.User.ResendVerificationEmail(ctx, getUserID(ctx))
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == user.ErrEmailVerified {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+func (api *API) authVerifyEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req tokenRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.User.VerifyEmail(ctx, req.Token)
+	if err == user.ErrInvalidToken || err == user.ErrTokenExpired {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == user.ErrEmailNotAvailable {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+func (api *API) meExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	exp, err := api.User.Export(ctx, getUserID(ctx))
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = exp.WriteZip(&buf)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wongnok-%s.zip"`, exp.Profile.Username))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
+
+func (api *API) meDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	err := api.User.Delete(ctx, getUserID(ctx))
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// session is revoked, browser should not keep sending it
+	api.clearSessionCookie(w)
+	encodeJSON(w, successResponse{true})
+}
+
+type identityItem struct {
+	Provider  string `json:"provider"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func (api *API) meListIdentities(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	identities, err := api.Auth.ListIdentities(ctx, getUserID(ctx))
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]*identityItem, 0, len(identities))
+	for _, x := range identities {
+		list = append(list, &identityItem{
+			Provider:  x.Provider,
+			Email:     x.Email,
+			CreatedAt: formatTime(x.CreatedAt),
+		})
+	}
+
+	encodeJSON(w, list)
+}
+
+func (api *API) meUnlinkIdentity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	err := api.Auth.UnlinkIdentity(ctx, getUserID(ctx), ps.ByName("provider"))
+	if err == auth.ErrProviderNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err == auth.ErrLastSignInMethod {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type enrollTwoFactorResponse struct {
+	Success bool   `json:"success"`
+	Secret  string `json:"secret"`
+	URI     string `json:"uri"`
+}
+
+func (api *API) meEnrollTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	secret, uri, err := api.Auth.EnrollTwoFactor(ctx, getUserID(ctx))
+	if err == auth.ErrTwoFactorEnabled {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	encodeJSON(w, enrollTwoFactorResponse{true, secret, uri})
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	Success       bool     `json:"success"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (api *API) meConfirmTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req codeRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	recoveryCodes, err := api.Auth.ConfirmTwoFactor(ctx, getUserID(ctx), req.Code)
+	if err == auth.ErrInvalidCode {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == auth.ErrTwoFactorEnabled || err == auth.ErrTwoFactorNotEnrolled {
+		handleError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	encodeJSON(w, recoveryCodesResponse{true, recoveryCodes})
+}
+
+func (api *API) meDisableTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req codeRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	err = api.Auth.DisableTwoFactor(ctx, getUserID(ctx), req.Code)
+	if err == auth.ErrInvalidCode {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
+
+type profileReviewItem struct {
+	ID        int64    `json:"id"`
+	ShopID    int64    `json:"shopId"`
+	ShopName  string   `json:"shopName"`
+	Rating    int      `json:"rating"`
+	Comment   string   `json:"comment"`
+	Photos    []string `json:"photos"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+type publicProfileResponse struct {
+	Username      string               `json:"username"`
+	DisplayName   string               `json:"displayName"`
+	AvatarURL     string               `json:"avatarUrl"`
+	Bio           string               `json:"bio"`
+	ReviewCount   int                  `json:"reviewCount"`
+	RecentReviews []*profileReviewItem `json:"recentReviews"`
+	CreatedAt     string               `json:"createdAt"`
+}
+
+func (api *API) userGetProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	profile, err := api.User.GetPublicProfile(ctx, ps.ByName("username"))
+	if err == user.ErrNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	reviews := make([]*profileReviewItem, 0, len(profile.RecentReviews))
+	for _, x := range profile.RecentReviews {
+		reviews = append(reviews, &profileReviewItem{
+			ID:        x.ID,
+			ShopID:    x.ShopID,
+			ShopName:  x.ShopName,
+			Rating:    x.Rating,
+			Comment:   x.Comment,
+			Photos:    x.Photos,
+			CreatedAt: formatTime(x.CreatedAt),
+		})
+	}
+
+	encodeJSON(w, publicProfileResponse{
+		Username:      profile.Username,
+		DisplayName:   profile.DisplayName,
+		AvatarURL:     profile.AvatarURL,
+		Bio:           profile.Bio,
+		ReviewCount:   profile.ReviewCount,
+		RecentReviews: reviews,
+		CreatedAt:     formatTime(profile.CreatedAt),
+	})
+}
+
+type apiKeyItem struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  *string  `json:"expiresAt"`
+	LastUsedAt *string  `json:"lastUsedAt"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+func newAPIKeyItem(x *auth.APIKey) *apiKeyItem {
+	item := apiKeyItem{
+		ID:        x.ID,
+		Name:      x.Name,
+		Prefix:    x.Prefix,
+		Scopes:    x.Scopes,
+		CreatedAt: formatTime(x.CreatedAt),
+	}
+	if item.Scopes == nil {
+		item.Scopes = []string{}
+	}
+	if x.ExpiresAt != nil {
+		s := formatTime(*x.ExpiresAt)
+		item.ExpiresAt = &s
+	}
+	if x.LastUsedAt != nil {
+		s := formatTime(*x.LastUsedAt)
+		item.LastUsedAt = &s
+	}
+	return &item
+}
+
+func (api *API) meListAPIKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx := r.Context()
+	keys, err := api.Auth.ListAPIKeys(ctx, getUserID(ctx))
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]*apiKeyItem, 0, len(keys))
+	for _, x := range keys {
+		list = append(list, newAPIKeyItem(x))
+	}
+
+	encodeJSON(w, list)
+}
+
+// meCreateAPIKey creates api key, the key is returned only once
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type createAPIKeyResponse struct {
+	Success bool   `json:"success"`
+	Key     string `json:"key"`
+	*apiKeyItem
+}
+
+func (api *API) meCreateAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req createAPIKeyRequest
+	err := decodeJSON(r, &req)
+	if err != nil {
+		handleError(w, decodeErrorStatus(err), err)
+		return
+	}
+
+	ctx := r.Context()
+	key, apiKey, err := api.Auth.CreateAPIKey(ctx, getUserID(ctx), req.Name, req.Scopes, req.ExpiresAt)
+	if err, ok := err.(*validate.Error); ok {
+		handleError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	encodeJSON(w, createAPIKeyResponse{true, key, newAPIKeyItem(apiKey)})
+}
+
+func (api *API) meRevokeAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	keyID, ok := paramID(ps, "id")
+	if !ok {
+		handleError(w, http.StatusNotFound, auth.ErrAPIKeyNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	err := api.Auth.RevokeAPIKey(ctx, getUserID(ctx), keyID)
+	if err == auth.ErrAPIKeyNotFound {
+		handleError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodeJSON(w, successResponse{true})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var errVersionSunset = errors.New("api version is no longer supported")
+
+// apiVersion is a route tree served under Prefix,
+// versions are served side by side so clients can migrate at their own pace
+type apiVersion struct {
+	Prefix string
+
+	// Deprecation is when the version was deprecated, zero for supported version
+	Deprecation time.Time
+
+	// Sunset is when the version stops responding
+	Sunset time.Time
+
+	// Successor is prefix of the version replacing this version
+	Successor string
+
+	// OptionalPrecondition serves updates without If-Match header,
+	// for clients shipped before If-Match was required
+	OptionalPrecondition bool
+
+	routes     func(api *API, router *groupRouter)
+	operations []operation
+}
+
+// apiVersions are served by API, add new version before deprecating the old one
+var apiVersions = []*apiVersion{
+	{
+		Prefix:     "/v1",
+		routes:     (*API).routesV1,
+		operations: operationsV1,
+	},
+
+	// unversioned routes are kept for shipped mobile apps,
+	// deprecation and sunset come from API's config
+	{
+		Prefix:               "",
+		Successor:            "/v1",
+		OptionalPrecondition: true,
+		routes:               (*API).routesLegacy,
+		operations:           operationsLegacy,
+	},
+}
+
+// legacyRoutes are routes served before versioning,
+// routes added later are served under versioned prefix only
+var legacyRoutes = map[Route]bool{
+	{"POST", "/auth/signup"}:                             true,
+	{"POST", "/auth/signin"}:                             true,
+	{"POST", "/auth/signin/2fa"}:                         true,
+	{"POST", "/auth/signout"}:                            true,
+	{"POST", "/auth/token"}:                              true,
+	{"POST", "/auth/verify-email"}:                       true,
+	{"POST", "/auth/oauth/:provider"}:                    true,
+	{"POST", "/auth/oauth/:provider/callback"}:           true,
+	{"GET", "/me"}:                                       true,
+	{"PATCH", "/me"}:                                     true,
+	{"DELETE", "/me"}:                                    true,
+	{"GET", "/me/export"}:                                true,
+	{"PUT", "/me/email"}:                                 true,
+	{"POST", "/me/email/resend"}:                         true,
+	{"GET", "/me/identities"}:                            true,
+	{"DELETE", "/me/identities/:provider"}:               true,
+	{"GET", "/me/api-keys"}:                              true,
+	{"POST", "/me/api-keys"}:                             true,
+	{"DELETE", "/me/api-keys/:id"}:                       true,
+	{"POST", "/me/2fa/enroll"}:                           true,
+	{"POST", "/me/2fa/confirm"}:                          true,
+	{"POST", "/me/2fa/disable"}:                          true,
+	{"GET", "/users/:username"}:                          true,
+	{"GET", "/shops"}:                                    true,
+	{"GET", "/shops/:id"}:                                true,
+	{"POST", "/shops/:id/claims"}:                        true,
+	{"POST", "/management/shops"}:                        true,
+	{"GET", "/management/shops"}:                         true,
+	{"PUT", "/management/shops/:id"}:                     true,
+	{"PUT", "/management/shops/:id/terms"}:               true,
+	{"PUT", "/management/shops/:id/opening-hours"}:       true,
+	{"GET", "/management/shops/:id/menu"}:                true,
+	{"PUT", "/management/shops/:id/menu"}:                true,
+	{"POST", "/management/categories"}:                   true,
+	{"GET", "/management/categories"}:                    true,
+	{"PUT", "/management/categories/:id"}:                true,
+	{"DELETE", "/management/categories/:id"}:             true,
+	{"POST", "/management/cuisines"}:                     true,
+	{"GET", "/management/cuisines"}:                      true,
+	{"PUT", "/management/cuisines/:id"}:                  true,
+	{"DELETE", "/management/cuisines/:id"}:               true,
+	{"POST", "/management/tags"}:                         true,
+	{"GET", "/management/tags"}:                          true,
+	{"PUT", "/management/tags/:id"}:                      true,
+	{"DELETE", "/management/tags/:id"}:                   true,
+	{"GET", "/management/claims"}:                        true,
+	{"POST", "/management/claims/:id/approve"}:           true,
+	{"POST", "/management/claims/:id/reject"}:            true,
+	{"DELETE", "/management/reviews/:id"}:                true,
+	{"GET", "/management/roles"}:                         true,
+	{"PUT", "/management/roles/:name"}:                   true,
+	{"PUT", "/management/users/:id/roles"}:               true,
+	{"GET", "/owner/shops"}:                              true,
+	{"PUT", "/owner/shops/:id"}:                          true,
+	{"PUT", "/owner/shops/:id/opening-hours"}:            true,
+	{"GET", "/owner/shops/:id/menu"}:                     true,
+	{"PUT", "/owner/shops/:id/menu"}:                     true,
+	{"POST", "/owner/shops/:id/reviews/:reviewID/reply"}: true,
+}
+
+// operationsLegacy are operationsV1 of legacyRoutes
+var operationsLegacy = func() []operation {
+	var ops []operation
+	for _, op := range operationsV1 {
+		if legacyRoutes[Route{op.Method, op.Path}] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}()
+
+// routesLegacy registers routesV1 which were served before versioning
+func (api *API) routesLegacy(router *groupRouter) {
+	router.only = func(method, path string) bool {
+		return legacyRoutes[Route{method, strings.TrimPrefix(path, router.prefix)}]
+	}
+	api.routesV1(router)
+}
+
+// versions returns apiVersions with legacy dates from api's config
+func (api *API) versions() []*apiVersion {
+	vs := make([]*apiVersion, len(apiVersions))
+	for i, v := range apiVersions {
+		if v.Prefix == "" {
+			x := *v
+			x.Deprecation = api.LegacyDeprecation
+			x.Sunset = api.LegacySunset
+			v = &x
+		}
+		vs[i] = v
+	}
+	return vs
+}
+
+// now returns current time from api's clock
+func (api *API) now() time.Time {
+	if api.Now != nil {
+		return api.Now()
+	}
+	return time.Now()
+}
+
+// name returns version's name for tests
+func (v *apiVersion) name() string {
+	if v.Prefix == "" {
+		return "legacy"
+	}
+	return strings.TrimPrefix(v.Prefix, "/")
+}
+
+// middleware announces deprecated version using Deprecation (RFC 9745),
+// Sunset (RFC 8594) and successor-version link headers,
+// then responds 410 after sunset
+func (v *apiVersion) middleware(now func() time.Time) middleware {
+	if v.Deprecation.IsZero() {
+		return nil
+	}
+
+	return func(h httprouter.Handle) httprouter.Handle {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			if !v.Sunset.IsZero() && !now().Before(v.Sunset) {
+				handleError(w, http.StatusGone, errVersionSunset)
+				return
+			}
+
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", v.Deprecation.Unix()))
+			if !v.Sunset.IsZero() {
+				w.Header().Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if v.Successor != "" {
+				successor := v.Successor + strings.TrimPrefix(r.URL.Path, v.Prefix)
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			}
+			h(w, r, ps)
+		}
+	}
+}
+
+// unversionedPath strips version prefix from path,
+// so the same path in every version shares settings
+func unversionedPath(path string) string {
+	for _, v := range apiVersions {
+		if v.Prefix != "" && strings.HasPrefix(path, v.Prefix+"/") {
+			return strings.TrimPrefix(path, v.Prefix)
+		}
+	}
+	return path
+}
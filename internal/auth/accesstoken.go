@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// revocationList is an in-memory set of revoked session ids,
+// entries are kept until every access token of the session expired
+type revocationList struct {
+	mu  sync.RWMutex
+	ids map[string]time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{ids: make(map[string]time.Time)}
+}
+
+func (l *revocationList) add(id string, expiresAt time.Time) {
+	l.mu.Lock()
+	l.ids[id] = expiresAt
+	l.mu.Unlock()
+}
+
+func (l *revocationList) has(id string, now time.Time) bool {
+	l.mu.RLock()
+	expiresAt, ok := l.ids[id]
+	l.mu.RUnlock()
+	return ok && now.Before(expiresAt)
+}
+
+func (l *revocationList) replace(ids map[string]time.Time) {
+	l.mu.Lock()
+	l.ids = ids
+	l.mu.Unlock()
+}
+
+// IssueAccessToken exchanges session token for short-lived signed access token,
+// which VerifyToken validates without database
+func (svc *Auth) IssueAccessToken(ctx context.Context, sessionToken string) (token string, expiresAt time.Time, err error) {
+	if svc.signer == nil {
+		return "", time.Time{}, ErrAccessTokenDisabled
+	}
+	if sessionToken == "" || isSignedToken(sessionToken) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+
+	now := time.Now()
+	sid := sessionID(sessionToken)
+	if svc.revocations.has(sid, now) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+
+	userID, permissions, err := svc.verifySession(ctx, sessionToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if userID == 0 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+
+	expiresAt = now.Add(svc.signer.TTL())
+	token, err = svc.signer.sign(&tokenClaims{
+		Subject:     userID,
+		SessionID:   sid,
+		ID:          generateToken(),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiresAt.Unix(),
+		Permissions: permissions,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// verifyAccessToken validates signed token locally,
+// only revocation list is checked and it lives in memory
+func (svc *Auth) verifyAccessToken(token string) (*tokenClaims, error) {
+	now := time.Now()
+	claims, err := svc.signer.verify(token, now)
+	if err == ErrTokenExpired {
+		return nil, err
+	}
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if svc.revocations.has(claims.SessionID, now) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// revokeSession adds session to revocation list,
+// all access tokens issued from the session become invalid
+func (svc *Auth) revokeSession(ctx context.Context, sid string) error {
+	expiresAt := time.Now().Add(svc.signer.TTL())
+	_, err := svc.db.ExecContext(ctx, `
+		insert into revoked_sessions
+			(id, expires_at)
+		values
+			($1, $2)
+		on conflict (id) do update
+			set expires_at = excluded.expires_at
+	`, sid, expiresAt)
+	if err != nil {
+		return err
+	}
+	svc.revocations.add(sid, expiresAt)
+	return nil
+}
+
+// RevokeUserSessions deletes all user's sessions and revokes access tokens issued from them,
+// call it when removing user outside auth service, ttl is access token's lifetime
+func RevokeUserSessions(ctx context.Context, db execer, userID int64, ttl time.Duration) error {
+	_, err := db.ExecContext(ctx, `
+		insert into revoked_sessions
+			(id, expires_at)
+		select session_id, now() + make_interval(secs => $2)
+		from auth_tokens
+		where user_id = $1
+		on conflict (id) do update
+			set expires_at = excluded.expires_at
+	`, userID, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		delete from auth_tokens
+		where user_id = $1
+	`, userID)
+	return err
+}
+
+// SyncRevocations reloads revocation list from database,
+// to pick up sign outs from other replicas
+func (svc *Auth) SyncRevocations(ctx context.Context) error {
+	_, err := svc.db.ExecContext(ctx, `
+		delete from revoked_sessions
+		where expires_at <= now()
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := svc.db.QueryContext(ctx, `
+		select id, expires_at
+		from revoked_sessions
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			id        string
+			expiresAt time.Time
+		)
+		err = rows.Scan(&id, &expiresAt)
+		if err != nil {
+			return err
+		}
+		ids[id] = expiresAt
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	svc.revocations.replace(ids)
+	return nil
+}
+
+// RunRevocationSync syncs revocation list every interval until ctx is canceled,
+// interval bounds how long a sign out takes to reach other replicas
+func (svc *Auth) RunRevocationSync(ctx context.Context, interval time.Duration) {
+	if svc.signer == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := svc.SyncRevocations(ctx)
+		if err != nil {
+			log.Println("auth: sync revocations error;", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// apiKeyPrefix marks api keys, to tell them apart from session tokens
+const apiKeyPrefix = "wk_"
+
+// APIKey is user's long-lived key for scripts,
+// key itself is shown only once when created
+type APIKey struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// apiKeyLength is length of api key, session tokens are shorter
+// so a session token which happens to start with prefix is not an api key
+const apiKeyLength = len(apiKeyPrefix) + 32
+
+func isAPIKey(token string) bool {
+	return len(token) == apiKeyLength && strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func generateAPIKey() string {
+	return apiKeyPrefix + generateRandomString(24)
+}
+
+// CreateAPIKey creates api key for user, scopes limit which of user's permissions
+// the key has, nil expiresAt never expires
+func (svc *Auth) CreateAPIKey(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (key string, apiKey *APIKey, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, validate.NewRequiredError("name")
+	}
+	if len(name) > 50 {
+		return "", nil, validate.NewError("name", "too long")
+	}
+	for _, s := range scopes {
+		if !isPermission(s) {
+			return "", nil, validate.NewError("scopes", "unknown permission "+s)
+		}
+	}
+	scopes = uniqueStrings(scopes)
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, validate.NewError("expiresAt", "must be in the future")
+	}
+
+	key = generateAPIKey()
+	apiKey = &APIKey{
+		Name:      name,
+		Prefix:    key[:len(apiKeyPrefix)+4],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err = svc.db.QueryRowContext(ctx, `
+		insert into api_keys
+			(user_id, name, key_hash, prefix, scopes, expires_at)
+		values
+			($1, $2, $3, $4, $5, $6)
+		returning id, created_at
+	`, userID, name, sessionID(key), apiKey.Prefix, pq.Array(scopes), expiresAt,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// ListAPIKeys retrieves user's api keys
+func (svc *Auth) ListAPIKeys(ctx context.Context, userID int64) ([]*APIKey, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			id, name, prefix, scopes, expires_at, last_used_at, created_at
+		from api_keys
+		where user_id = $1
+		order by created_at desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		var x APIKey
+		err = rows.Scan(&x.ID, &x.Name, &x.Prefix, pq.Array(&x.Scopes), &x.ExpiresAt, &x.LastUsedAt, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &x)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey deletes user's api key
+func (svc *Auth) RevokeAPIKey(ctx context.Context, userID, keyID int64) error {
+	var keyHash string
+	err := svc.db.QueryRowContext(ctx, `
+		delete from api_keys
+		where id = $1 and user_id = $2
+		returning key_hash
+	`, keyID, userID).Scan(&keyHash)
+	if err == sql.ErrNoRows {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return svc.invalidateCache(ctx, invalidateSession(keyHash))
+}
+
+// verifyAPIKey returns key's user and permissions granted by both user's roles and key's scopes
+func (svc *Auth) verifyAPIKey(ctx context.Context, key string) (userID int64, permissions []string, err error) {
+	var (
+		keyID            int64
+		twoFactorEnabled bool
+		scopes           []string
+		userPermissions  []string
+	)
+	err = svc.db.QueryRowContext(ctx, `
+		select
+			api_keys.id, api_keys.user_id, users.totp_enabled, api_keys.scopes,
+			coalesce(array_agg(distinct role_permissions.permission)
+				filter (where role_permissions.permission is not null), '{}')
+		from api_keys
+		inner join users on api_keys.user_id = users.id
+		left join user_roles on user_roles.user_id = api_keys.user_id
+		left join role_permissions on role_permissions.role = user_roles.role
+		where api_keys.key_hash = $1
+			and (api_keys.expires_at is null or api_keys.expires_at > now())
+			and users.deleted_at is null
+		group by api_keys.id, users.totp_enabled
+	`, sessionID(key)).Scan(&keyID, &userID, &twoFactorEnabled, pq.Array(&scopes), pq.Array(&userPermissions))
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// verified keys are cached, last used is accurate to cache ttl
+	_, err = svc.db.ExecContext(ctx, `
+		update api_keys
+		set last_used_at = now()
+		where id = $1
+			and (last_used_at is null or last_used_at < now() - interval '1 minute')
+	`, keyID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if svc.requireTwoFactorForAdmin && !twoFactorEnabled {
+		return userID, nil, nil
+	}
+	return userID, intersectStrings(userPermissions, scopes), nil
+}
+
+func intersectStrings(xs, ys []string) []string {
+	set := make(map[string]bool)
+	for _, y := range ys {
+		set[y] = true
+	}
+
+	var r []string
+	for _, x := range xs {
+		if set[x] {
+			r = append(r, x)
+		}
+	}
+	return r
+}
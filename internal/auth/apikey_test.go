@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_generateAPIKey(t *testing.T) {
+	key := generateAPIKey()
+	assert.True(t, isAPIKey(key))
+	assert.False(t, isSignedToken(key))
+	assert.NotEqual(t, key, generateAPIKey())
+	assert.False(t, isAPIKey(generateToken()), "expected session token is not api key")
+}
+
+func Test_intersectStrings(t *testing.T) {
+	cases := []struct {
+		Name string
+		xs   []string
+		ys   []string
+		out  []string
+	}{
+		{"no scopes", []string{PermissionShopCreate}, nil, nil},
+		{"scope user does not have", []string{PermissionShopCreate}, []string{PermissionUserManage}, nil},
+		{
+			"subset",
+			[]string{PermissionShopCreate, PermissionShopManage, PermissionUserManage},
+			[]string{PermissionShopManage, PermissionUserManage},
+			[]string{PermissionShopManage, PermissionUserManage},
+		},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			assert.Equal(t, tC.out, intersectStrings(tC.xs, tC.ys))
+		})
+	}
+}
+
+func Test_uniqueStrings(t *testing.T) {
+	cases := []struct {
+		Name string
+		xs   []string
+		out  []string
+	}{
+		{"nil", nil, []string{}},
+		{"empty", []string{}, []string{}},
+		{
+			"duplicated",
+			[]string{PermissionShopCreate, PermissionShopManage, PermissionShopCreate},
+			[]string{PermissionShopCreate, PermissionShopManage},
+		},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			out := uniqueStrings(tC.xs)
+			assert.NotNil(t, out, "expected not nil for not null column")
+			assert.Equal(t, tC.out, out)
+		})
+	}
+}
@@ -4,16 +4,61 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log"
 	"regexp"
 	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/acoshift/wongnok/internal/validate"
 )
 
 // Auth service
 type Auth struct {
-	db   *sql.DB
-	repo repository
+	db        *sql.DB
+	repo      repository
+	providers map[string]Provider
+
+	requireTwoFactorForAdmin bool
+
+	signer      *Signer
+	revocations *revocationList
+	cache       *tokenCache
+
+	passwordHasher *PasswordHasher
+	passwordPolicy *PasswordPolicy
+}
+
+// Config is auth service's config
+type Config struct {
+	// Providers are external identity providers users can sign in with
+	Providers []Provider
+
+	// RequireTwoFactorForAdmin drops all permissions of users
+	// who have any permission until they enable two-factor
+	RequireTwoFactorForAdmin bool
+
+	// Signer enables stateless access tokens, nil disables.
+	// Permission changes apply to access tokens only after they expired,
+	// keep signer's TTL short
+	Signer *Signer
+
+	// PasswordHasher hashes new passwords, nil uses bcrypt with default cost.
+	// Passwords hashed differently are rehashed on sign in
+	PasswordHasher *PasswordHasher
+
+	// PasswordPolicy validates passwords on sign up, nil checks only length
+	PasswordPolicy *PasswordPolicy
+
+	// CacheSize is max number of cached sessions, 0 disables cache
+	CacheSize int
+
+	// CacheTTL is how long verified session is cached, default 1 minute
+	CacheTTL time.Duration
+
+	// NegativeCacheTTL is how long unknown token is cached, default 10 seconds
+	NegativeCacheTTL time.Duration
 }
 
 type repository interface {
@@ -22,17 +67,43 @@ type repository interface {
 }
 
 // New creates new auth service
-func New(db *sql.DB) *Auth {
-	return &Auth{db, repo{}}
+func New(db *sql.DB, config Config) *Auth {
+	providers := make(map[string]Provider)
+	for _, p := range config.Providers {
+		providers[p.Name()] = p
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = time.Minute
+	}
+	if config.NegativeCacheTTL <= 0 {
+		config.NegativeCacheTTL = 10 * time.Second
+	}
+	return &Auth{
+		db:                       db,
+		repo:                     repo{},
+		providers:                providers,
+		requireTwoFactorForAdmin: config.RequireTwoFactorForAdmin,
+		signer:                   config.Signer,
+		revocations:              newRevocationList(),
+		cache:                    newTokenCache(config.CacheSize, config.CacheTTL, config.NegativeCacheTTL),
+		passwordHasher:           config.PasswordHasher,
+		passwordPolicy:           config.PasswordPolicy,
+	}
 }
 
 var reUsername = regexp.MustCompile(`^[a-z0-9]*$`)
 
+// NormalizeUsername normalizes username the same way as when user sign up
+func NormalizeUsername(username string) string {
+	username = strings.ToLower(username)
+	username = strings.TrimSpace(username)
+	return username
+}
+
 // SignUp registers new user
 func (svc *Auth) SignUp(ctx context.Context, username, password string) (userID int64, err error) {
 	// normalize data
-	username = strings.ToLower(username)
-	username = strings.TrimSpace(username)
+	username = NormalizeUsername(username)
 
 	// validate
 	if username == "" {
@@ -56,9 +127,18 @@ func (svc *Auth) SignUp(ctx context.Context, username, password string) (userID
 	if len(password) > 64 {
 		return 0, validate.NewError("password", "too long")
 	}
+	if svc.passwordPolicy != nil {
+		err = svc.passwordPolicy.validate(ctx, username, password)
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	// hash password
-	hashedPass := hashPassword(password)
+	hashedPass, err := svc.hasher().hash(password)
+	if err != nil {
+		return 0, err
+	}
 
 	userID, err = svc.repo.InsertUser(ctx, svc.db, username, hashedPass)
 	if err != nil {
@@ -68,52 +148,86 @@ func (svc *Auth) SignUp(ctx context.Context, username, password string) (userID
 }
 
 // SignIn sign in user
-func (svc *Auth) SignIn(ctx context.Context, username, password string) (token string, err error) {
-	username = strings.ToLower(username)
-	username = strings.TrimSpace(username)
+func (svc *Auth) SignIn(ctx context.Context, username, password string) (*SignInResult, error) {
+	username = NormalizeUsername(username)
 
 	if username == "" {
-		return "", fmt.Errorf("username required")
+		return nil, fmt.Errorf("username required")
 	}
 	if len(username) > 20 {
-		return "", fmt.Errorf("username too long")
+		return nil, fmt.Errorf("username too long")
 	}
 	if password == "" {
-		return "", fmt.Errorf("password required")
+		return nil, fmt.Errorf("password required")
 	}
 	if len(password) > 64 {
-		return "", fmt.Errorf("password too long")
+		return nil, fmt.Errorf("password too long")
 	}
 
 	var (
 		userID       int64
 		userPassword string
 	)
-	err = svc.db.QueryRowContext(ctx, `
+	err := svc.db.QueryRowContext(ctx, `
 		select
 			id, password
 		from users
-		where username = $1
+		where username = $1 and deleted_at is null
 	`, username).Scan(&userID, &userPassword)
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("invalid credentials")
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !compareHashAndPassword(userPassword, password) {
-		return "", fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
+	// upgrade hash while we have plain password, sign in still succeeds on error
+	if svc.hasher().needsRehash(userPassword) {
+		err = svc.rehashPassword(ctx, userID, userPassword, password)
+		if err != nil {
+			log.Println("auth: rehash password error;", err)
+		}
 	}
 
+	return svc.completeSignIn(ctx, userID)
+}
+
+func (svc *Auth) hasher() *PasswordHasher {
+	if svc.passwordHasher == nil {
+		return &PasswordHasher{}
+	}
+	return svc.passwordHasher
+}
+
+// rehashPassword replaces user's password hash, unless password was changed meanwhile
+func (svc *Auth) rehashPassword(ctx context.Context, userID int64, oldHash, password string) error {
+	hashed, err := svc.hasher().hash(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.db.ExecContext(ctx, `
+		update users
+		set password = $3
+		where id = $1 and password = $2
+	`, userID, oldHash, hashed)
+	return err
+}
+
+// createToken creates new session token for user
+func (svc *Auth) createToken(ctx context.Context, userID int64) (token string, err error) {
 	token = generateToken()
 
 	_, err = svc.db.ExecContext(ctx, `
 		insert into auth_tokens
-			(id, user_id)
+			(id, session_id, user_id)
 		values
-			($1, $2)
-	`, token, userID)
+			($1, $2, $3)
+	`, token, sessionID(token), userID)
 	if err != nil {
 		return "", err
 	}
@@ -121,33 +235,123 @@ func (svc *Auth) SignIn(ctx context.Context, username, password string) (token s
 	return token, nil
 }
 
-// SignOut sign out user
+// SignOut sign out user, token can be either session token or access token.
+// Access tokens issued from the session are revoked when signer enabled
 func (svc *Auth) SignOut(ctx context.Context, token string) error {
 	if token == "" {
 		return fmt.Errorf("token required")
 	}
 
-	return svc.repo.DeleteToken(ctx, svc.db, token)
+	if svc.signer == nil {
+		return svc.deleteSession(ctx, token)
+	}
+
+	if isSignedToken(token) {
+		claims, err := svc.signer.verify(token, time.Now())
+		if err != nil {
+			return ErrInvalidToken
+		}
+		err = svc.revokeSession(ctx, claims.SessionID)
+		if err != nil {
+			return err
+		}
+
+		// access token does not contain session token, lookup by session id
+		_, err = svc.db.ExecContext(ctx, `
+			delete from auth_tokens
+			where session_id = $1
+		`, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		return svc.invalidateCache(ctx, invalidateSession(claims.SessionID))
+	}
+
+	err := svc.revokeSession(ctx, sessionID(token))
+	if err != nil {
+		return err
+	}
+	return svc.deleteSession(ctx, token)
+}
+
+func (svc *Auth) deleteSession(ctx context.Context, token string) error {
+	err := svc.repo.DeleteToken(ctx, svc.db, token)
+	if err != nil {
+		return err
+	}
+	return svc.invalidateCache(ctx, invalidateSession(sessionID(token)))
+}
+
+// invalidateCache invalidates local cache and broadcasts to other replicas
+func (svc *Auth) invalidateCache(ctx context.Context, msg string) error {
+	if svc.cache == nil {
+		return nil
+	}
+	svc.cache.invalidate(msg)
+	return notifyCache(ctx, svc.db, msg)
 }
 
-// VerifyToken returns user id if token valid
-func (svc *Auth) VerifyToken(ctx context.Context, token string) (userID int64, isAdmin bool, err error) {
+// VerifyToken returns user id and user's permissions if token valid,
+// token can be session token, access token or api key.
+// Returns ErrInvalidToken for unknown or revoked token,
+// and ErrTokenExpired for expired access token
+func (svc *Auth) VerifyToken(ctx context.Context, token string) (userID int64, permissions []string, err error) {
 	if token == "" {
-		return 0, false, nil
+		return 0, nil, ErrInvalidToken
+	}
+
+	if svc.signer != nil && isSignedToken(token) {
+		claims, err := svc.verifyAccessToken(token)
+		if err != nil {
+			return 0, nil, err
+		}
+		return claims.Subject, claims.Permissions, nil
+	}
+
+	sid := sessionID(token)
+	now := time.Now()
+	e, ok := svc.cache.get(sid, now)
+	if !ok {
+		if isAPIKey(token) {
+			userID, permissions, err = svc.verifyAPIKey(ctx, token)
+		} else {
+			userID, permissions, err = svc.verifySession(ctx, token)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		svc.cache.set(sid, userID, permissions, now)
+		e = &cacheEntry{userID: userID, permissions: permissions}
+	}
+	if e.userID == 0 {
+		return 0, nil, ErrInvalidToken
 	}
+	return e.userID, e.permissions, nil
+}
 
+// verifySession looks up session token from database
+func (svc *Auth) verifySession(ctx context.Context, token string) (userID int64, permissions []string, err error) {
+	var twoFactorEnabled bool
 	err = svc.db.QueryRowContext(ctx, `
 		select
-			auth_tokens.user_id, users.is_admin
+			auth_tokens.user_id, users.totp_enabled,
+			coalesce(array_agg(distinct role_permissions.permission)
+				filter (where role_permissions.permission is not null), '{}')
 		from auth_tokens
 		left join users on auth_tokens.user_id = users.id
+		left join user_roles on user_roles.user_id = auth_tokens.user_id
+		left join role_permissions on role_permissions.role = user_roles.role
 		where auth_tokens.id = $1
-	`, token).Scan(&userID, &isAdmin)
+		group by auth_tokens.user_id, users.totp_enabled
+	`, token).Scan(&userID, &twoFactorEnabled, pq.Array(&permissions))
 	if err == sql.ErrNoRows {
-		return 0, false, nil
+		return 0, nil, nil
 	}
 	if err != nil {
-		return 0, false, err
+		return 0, nil, err
+	}
+	if svc.requireTwoFactorForAdmin && !twoFactorEnabled {
+		permissions = nil
 	}
-	return userID, isAdmin, nil
+	return userID, permissions, nil
 }
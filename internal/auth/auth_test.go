@@ -161,3 +161,9 @@ func (f *fakeRepo) InsertUser(ctx context.Context, db *sql.DB, username, passwor
 func (f *fakeRepo) DeleteToken(ctx context.Context, db *sql.DB, token string) error {
 	return nil
 }
+
+func TestNormalizeUsername(t *testing.T) {
+	assert.Equal(t, "tester", NormalizeUsername("  Tester "))
+	assert.Equal(t, "tester", NormalizeUsername("tester"))
+	assert.Equal(t, "", NormalizeUsername(" "))
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// cacheChannel is postgres channel for broadcasting token cache invalidation
+const cacheChannel = "auth_token_cache"
+
+// invalidation messages
+const invalidateAll = "all"
+
+func invalidateSession(sid string) string {
+	return "session:" + sid
+}
+
+func invalidateUser(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+type cacheEntry struct {
+	sid         string
+	userID      int64
+	permissions []string
+	expiresAt   time.Time
+}
+
+// tokenCache is LRU cache for session verification results keyed by session id,
+// unknown tokens are cached with userID 0 for shorter ttl.
+// nil cache is valid and caches nothing
+type tokenCache struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	ll          *list.List
+	items       map[string]*list.Element
+}
+
+func newTokenCache(size int, ttl, negativeTTL time.Duration) *tokenCache {
+	if size <= 0 {
+		return nil
+	}
+	return &tokenCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+	}
+}
+
+func (c *tokenCache) get(sid string, now time.Time) (*cacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[sid]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !now.Before(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e, true
+}
+
+func (c *tokenCache) set(sid string, userID int64, permissions []string, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	ttl := c.ttl
+	if userID == 0 {
+		ttl = c.negativeTTL
+	}
+	e := &cacheEntry{sid, userID, permissions, now.Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[sid]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[sid] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *tokenCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).sid)
+}
+
+// invalidate applies invalidation message
+func (c *tokenCache) invalidate(msg string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(msg, "session:"):
+		if el, ok := c.items[strings.TrimPrefix(msg, "session:")]; ok {
+			c.removeElement(el)
+		}
+	case strings.HasPrefix(msg, "user:"):
+		userID, err := strconv.ParseInt(strings.TrimPrefix(msg, "user:"), 10, 64)
+		if err != nil {
+			return
+		}
+		for el := c.ll.Front(); el != nil; {
+			next := el.Next()
+			if el.Value.(*cacheEntry).userID == userID {
+				c.removeElement(el)
+			}
+			el = next
+		}
+	default:
+		c.ll.Init()
+		c.items = make(map[string]*list.Element)
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// notifyCache broadcasts invalidation message to all replicas,
+// when db is transaction, message is sent on commit
+func notifyCache(ctx context.Context, db execer, msg string) error {
+	_, err := db.ExecContext(ctx, `select pg_notify($1, $2)`, cacheChannel, msg)
+	return err
+}
+
+// NotifyUserChanged invalidates cached sessions of user on all replicas,
+// call it after changing user's sessions or permissions outside auth service
+func NotifyUserChanged(ctx context.Context, db execer, userID int64) error {
+	return notifyCache(ctx, db, invalidateUser(userID))
+}
+
+// RunCacheInvalidation listens for invalidation messages from other replicas
+// until ctx is canceled, dataSource is postgres connection string
+func (svc *Auth) RunCacheInvalidation(ctx context.Context, dataSource string) {
+	if svc.cache == nil {
+		return
+	}
+
+	l := pq.NewListener(dataSource, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("auth: cache listener error;", err)
+		}
+	})
+	defer l.Close()
+
+	err := l.Listen(cacheChannel)
+	if err != nil {
+		log.Println("auth: can not listen for cache invalidation;", err)
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.Notify:
+			// nil after reconnect, messages may be lost
+			if n == nil {
+				svc.cache.invalidate(invalidateAll)
+				continue
+			}
+			svc.cache.invalidate(n.Extra)
+		case <-ticker.C:
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+
+	t.Run("TTL", func(t *testing.T) {
+		c := newTokenCache(10, time.Minute, time.Second)
+		c.set("a", 1, []string{PermissionShopCreate}, now)
+		c.set("unknown", 0, nil, now)
+
+		e, ok := c.get("a", now.Add(30*time.Second))
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, e.userID)
+		assert.Equal(t, []string{PermissionShopCreate}, e.permissions)
+
+		e, ok = c.get("unknown", now)
+		assert.True(t, ok, "expected unknown token cached")
+		assert.EqualValues(t, 0, e.userID)
+
+		_, ok = c.get("unknown", now.Add(time.Second))
+		assert.False(t, ok, "expected negative entry expired")
+		_, ok = c.get("a", now.Add(time.Minute))
+		assert.False(t, ok, "expected entry expired")
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		c := newTokenCache(2, time.Minute, time.Second)
+		c.set("a", 1, nil, now)
+		c.set("b", 2, nil, now)
+		c.get("a", now)
+		c.set("c", 3, nil, now)
+
+		_, ok := c.get("b", now)
+		assert.False(t, ok, "expected least recently used evicted")
+		_, ok = c.get("a", now)
+		assert.True(t, ok)
+		_, ok = c.get("c", now)
+		assert.True(t, ok)
+	})
+
+	t.Run("Invalidate", func(t *testing.T) {
+		c := newTokenCache(10, time.Minute, time.Second)
+		c.set("a", 1, nil, now)
+		c.set("b", 1, nil, now)
+		c.set("c", 2, nil, now)
+		c.set("d", 3, nil, now)
+
+		c.invalidate(invalidateSession("d"))
+		_, ok := c.get("d", now)
+		assert.False(t, ok)
+
+		c.invalidate(invalidateUser(1))
+		_, ok = c.get("a", now)
+		assert.False(t, ok)
+		_, ok = c.get("b", now)
+		assert.False(t, ok)
+		_, ok = c.get("c", now)
+		assert.True(t, ok)
+
+		c.invalidate(invalidateAll)
+		_, ok = c.get("c", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		c := newTokenCache(0, time.Minute, time.Second)
+		assert.Nil(t, c)
+		c.set("a", 1, nil, now)
+		_, ok := c.get("a", now)
+		assert.False(t, ok)
+		c.invalidate(invalidateAll)
+	})
+}
@@ -11,4 +11,18 @@ var (
 	ErrUsernameTooLong      = errors.New("auth: username too long")
 	ErrUsernameInvalid      = errors.New("auth: username invalid")
 	ErrUsernameNotAvailable = errors.New("auth: username not available")
+	ErrUserNotFound         = errors.New("auth: user not found")
+	ErrProviderNotFound     = errors.New("auth: provider not found")
+	ErrInvalidState         = errors.New("auth: invalid state")
+	ErrIdentityLinked       = errors.New("auth: identity already linked to another user")
+	ErrLastSignInMethod     = errors.New("auth: can not unlink last sign in method")
+	ErrInvalidChallenge     = errors.New("auth: invalid challenge")
+	ErrInvalidCode          = errors.New("auth: invalid code")
+	ErrTwoFactorEnabled     = errors.New("auth: two-factor already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("auth: two-factor not enrolled")
+	ErrTwoFactorLocked      = errors.New("auth: too many two-factor attempts")
+	ErrInvalidToken         = errors.New("auth: invalid token")
+	ErrTokenExpired         = errors.New("auth: token expired")
+	ErrAccessTokenDisabled  = errors.New("auth: access token disabled")
+	ErrAPIKeyNotFound       = errors.New("auth: api key not found")
 )
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// oauthStateTTL is the duration user has to complete provider's consent
+const oauthStateTTL = 10 * time.Minute
+
+// StartOAuth starts authorization code flow with PKCE and returns provider's url
+// with nonce which must be kept by the client started the flow, ex. in cookie.
+// When userID is not 0, the identity will be linked to the user.
+func (svc *Auth) StartOAuth(ctx context.Context, provider string, userID int64) (authURL, nonce string, err error) {
+	p := svc.providers[provider]
+	if p == nil {
+		return "", "", ErrProviderNotFound
+	}
+
+	state := generateToken()
+	verifier := generateCodeVerifier()
+	nonce = generateToken()
+
+	var linkUserID *int64
+	if userID != 0 {
+		linkUserID = &userID
+	}
+
+	_, err = svc.db.ExecContext(ctx, `
+		insert into oauth_states
+			(id, provider, code_verifier, nonce_hash, user_id)
+		values
+			($1, $2, $3, $4, $5)
+	`, state, provider, verifier, sessionID(nonce), linkUserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return p.AuthCodeURL(state, codeChallenge(verifier)), nonce, nil
+}
+
+// CompleteOAuth completes authorization code flow and signs user in.
+// Nonce from StartOAuth ties the callback to the client started the flow,
+// so other's provider url can not link or sign in to attacker's account.
+// New user is created when the identity is not linked to any user.
+func (svc *Auth) CompleteOAuth(ctx context.Context, provider, state, nonce, code string) (*SignInResult, error) {
+	p := svc.providers[provider]
+	if p == nil {
+		return nil, ErrProviderNotFound
+	}
+	if state == "" || nonce == "" || code == "" {
+		return nil, ErrInvalidState
+	}
+
+	// state is single-use
+	var (
+		verifier   string
+		nonceHash  string
+		linkUserID *int64
+	)
+	err := svc.db.QueryRowContext(ctx, `
+		delete from oauth_states
+		where id = $1 and provider = $2 and created_at > $3
+		returning code_verifier, nonce_hash, user_id
+	`, state, provider, time.Now().Add(-oauthStateTTL)).Scan(&verifier, &nonceHash, &linkUserID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(nonceHash), []byte(sessionID(nonce))) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	identity, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID int64
+	err = svc.db.QueryRowContext(ctx, `
+		select user_identities.user_id
+		from user_identities
+		left join users on user_identities.user_id = users.id
+		where user_identities.provider = $1
+			and user_identities.subject = $2
+			and users.deleted_at is null
+	`, identity.Provider, identity.Subject).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	switch {
+	case linkUserID != nil && userID != 0 && userID != *linkUserID:
+		return nil, ErrIdentityLinked
+	case linkUserID != nil && userID == 0:
+		userID = *linkUserID
+		err = svc.linkUserIdentity(ctx, userID, identity)
+	case userID == 0:
+		userID, err = svc.createExternalUser(ctx, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.completeSignIn(ctx, userID)
+}
+
+// linkUserIdentity links identity to existing user
+func (svc *Auth) linkUserIdentity(ctx context.Context, userID int64, identity *Identity) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = linkIdentity(ctx, tx, userID, identity)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkIdentity links identity to user,
+// identity of deleted user is released so the person can sign up again
+func linkIdentity(ctx context.Context, tx *sql.Tx, userID int64, identity *Identity) error {
+	_, err := tx.ExecContext(ctx, `
+		delete from user_identities
+		using users
+		where user_identities.user_id = users.id
+			and user_identities.provider = $1
+			and user_identities.subject = $2
+			and users.deleted_at is not null
+	`, identity.Provider, identity.Subject)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into user_identities
+			(provider, subject, user_id, email)
+		values
+			($1, $2, $3, $4)
+	`, identity.Provider, identity.Subject, userID, identity.Email)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+var reNonUsername = regexp.MustCompile(`[^a-z0-9]`)
+
+// createExternalUser creates new user without password for the identity
+func (svc *Auth) createExternalUser(ctx context.Context, identity *Identity) (userID int64, err error) {
+	prefix := reNonUsername.ReplaceAllString(NormalizeUsername(identity.Provider), "")
+	if len(prefix) > 8 {
+		prefix = prefix[:8]
+	}
+
+	for i := 0; i < 5; i++ {
+		userID, err = svc.insertExternalUser(ctx, prefix+generateDigits(8), identity)
+		if err == ErrUsernameNotAvailable {
+			continue
+		}
+		return userID, err
+	}
+	return 0, ErrUsernameNotAvailable
+}
+
+// insertExternalUser inserts user and links identity in one transaction,
+// user without identity could not sign in
+func (svc *Auth) insertExternalUser(ctx context.Context, username string, identity *Identity) (userID int64, err error) {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// empty password can not be used to sign in
+	userID, err = insertUser(ctx, tx, username, "")
+	if err != nil {
+		return 0, err
+	}
+
+	err = linkIdentity(ctx, tx, userID, identity)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// LinkedIdentity is the identity linked to user
+type LinkedIdentity struct {
+	Provider  string
+	Email     string
+	CreatedAt time.Time
+}
+
+// ListIdentities retrieves identities linked to user
+func (svc *Auth) ListIdentities(ctx context.Context, userID int64) ([]*LinkedIdentity, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			provider, email, created_at
+		from user_identities
+		where user_id = $1
+		order by provider
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*LinkedIdentity
+	for rows.Next() {
+		var x LinkedIdentity
+		err = rows.Scan(&x.Provider, &x.Email, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &x)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity removes provider's identity from user,
+// user must still have password or other identity to sign in
+func (svc *Auth) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		hasPassword bool
+		count       int
+	)
+	err = tx.QueryRowContext(ctx, `
+		select
+			password <> '',
+			(select count(*) from user_identities where user_id = users.id)
+		from users
+		where id = $1
+		for update
+	`, userID).Scan(&hasPassword, &count)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		delete from user_identities
+		where user_id = $1 and provider = $2
+	`, userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrProviderNotFound
+	}
+	if !hasPassword && count <= 1 {
+		return ErrLastSignInMethod
+	}
+
+	return tx.Commit()
+}
@@ -1,19 +1,155 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
 
-func hashPassword(password string) string {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		panic(err)
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// PasswordHasher hashes passwords, zero value uses bcrypt with default cost
+type PasswordHasher struct {
+	Algorithm string
+
+	// BcryptCost default bcrypt.DefaultCost
+	BcryptCost int
+
+	// Argon2 parameters, default 1 iteration, 64 MiB and 4 threads
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (h *PasswordHasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
+}
+
+func (h *PasswordHasher) argon2Params() argon2Params {
+	p := argon2Params{h.Argon2Time, h.Argon2Memory, h.Argon2Threads}
+	if p.Time == 0 {
+		p.Time = 1
+	}
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Threads == 0 {
+		p.Threads = 4
+	}
+	return p
+}
+
+func (h *PasswordHasher) hash(password string) (string, error) {
+	switch h.Algorithm {
+	case "", HashBcrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLength)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+		return h.argon2Params().hash(password, salt), nil
+	}
+	return "", fmt.Errorf("auth: unknown password hashing algorithm %s", h.Algorithm)
+}
+
+// needsRehash returns true if hashed was not hashed by current algorithm and parameters
+func (h *PasswordHasher) needsRehash(hashed string) bool {
+	// user without password, e.g. signed up with external provider
+	if hashed == "" {
+		return false
 	}
-	return string(hashed)
+
+	switch h.Algorithm {
+	case "", HashBcrypt:
+		cost, err := bcrypt.Cost([]byte(hashed))
+		return err != nil || cost != h.bcryptCost()
+	case HashArgon2id:
+		p, _, _, err := parseArgon2Hash(hashed)
+		return err != nil || p != h.argon2Params()
+	}
+	return false
 }
 
+// compareHashAndPassword compares password with hashed from any supported algorithm
 func compareHashAndPassword(hashed, password string) bool {
+	if strings.HasPrefix(hashed, "$argon2id$") {
+		p, salt, key, err := parseArgon2Hash(hashed)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
 	if err != nil {
 		return false
 	}
 	return true
 }
+
+type argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// hash encodes argon2id hash in PHC string format
+func (p argon2Params) hash(password string, salt []byte) string {
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func parseArgon2Hash(hashed string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("auth: invalid argon2id hash")
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("auth: unsupported argon2 version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("auth: invalid argon2id params")
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
@@ -1,8 +1,20 @@
 package auth
 
-import "testing"
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func TestPassword(t *testing.T) {
+	hashers := []struct {
+		Name   string
+		Hasher *PasswordHasher
+	}{
+		{"bcrypt", &PasswordHasher{BcryptCost: bcrypt.MinCost}},
+		{"argon2id", &PasswordHasher{Algorithm: HashArgon2id, Argon2Memory: 1024}},
+	}
+
 	// Test Table
 	cases := []struct {
 		Name            string
@@ -15,18 +27,53 @@ func TestPassword(t *testing.T) {
 		{"Compare with invalid password", "superman", "batman", false},
 	}
 
+	for _, h := range hashers {
+		for _, tC := range cases {
+			t.Run(h.Name+"/"+tC.Name, func(t *testing.T) {
+				hashed, err := h.Hasher.hash(tC.Password)
+				if err != nil {
+					t.Fatalf("expected no error from hash; got %v", err)
+				}
+				if hashed == "" {
+					t.Errorf("expected non-empty string from hash; got empty")
+				}
+				if compareHashAndPassword(hashed, tC.ComparePassword) != tC.Equal {
+					if tC.Equal {
+						t.Errorf("expected hashed and password are equal; got not equal")
+					} else {
+						t.Errorf("expected hashed and password are not equal; got equal")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPasswordHasher_needsRehash(t *testing.T) {
+	bcryptHasher := &PasswordHasher{BcryptCost: bcrypt.MinCost}
+	argon2Hasher := &PasswordHasher{Algorithm: HashArgon2id, Argon2Memory: 1024}
+
+	bcryptHash, _ := bcryptHasher.hash("superman")
+	argon2Hash, _ := argon2Hasher.hash("superman")
+
+	cases := []struct {
+		Name   string
+		Hasher *PasswordHasher
+		Hashed string
+		Rehash bool
+	}{
+		{"Same bcrypt cost", bcryptHasher, bcryptHash, false},
+		{"Different bcrypt cost", &PasswordHasher{BcryptCost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"bcrypt to argon2id", argon2Hasher, bcryptHash, true},
+		{"argon2id to bcrypt", bcryptHasher, argon2Hash, true},
+		{"Same argon2id params", argon2Hasher, argon2Hash, false},
+		{"Different argon2id params", &PasswordHasher{Algorithm: HashArgon2id, Argon2Memory: 2048}, argon2Hash, true},
+		{"No password", bcryptHasher, "", false},
+	}
 	for _, tC := range cases {
 		t.Run(tC.Name, func(t *testing.T) {
-			hashed := hashPassword(tC.Password)
-			if hashed == "" {
-				t.Errorf("expected non-empty string from hashPassword; got empty")
-			}
-			if compareHashAndPassword(hashed, tC.ComparePassword) != tC.Equal {
-				if tC.Equal {
-					t.Errorf("expected hashed and password are equal; got not equal")
-				} else {
-					t.Errorf("expected hashed and password are not equal; got equal")
-				}
+			if got := tC.Hasher.needsRehash(tC.Hashed); got != tC.Rehash {
+				t.Errorf("expected needsRehash %v; got %v", tC.Rehash, got)
 			}
 		})
 	}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// PasswordPolicy validates new passwords
+type PasswordPolicy struct {
+	// MinScore is minimum strength score from 0 (too guessable) to 4 (very unguessable)
+	MinScore int
+
+	// Breached checks password against breached passwords, nil skips the check
+	Breached BreachRange
+}
+
+// BreachRange returns SHA-1 hash suffixes of breached passwords which start with prefix,
+// prefix is the first 5 hex characters so password's hash never leaves the caller (k-anonymity)
+type BreachRange interface {
+	Range(ctx context.Context, prefix string) (suffixes map[string]bool, err error)
+}
+
+// validate validates password for username
+func (p *PasswordPolicy) validate(ctx context.Context, username, password string) error {
+	if username != "" && strings.Contains(strings.ToLower(password), username) {
+		return validate.NewError("password", "must not contain username")
+	}
+	if passwordScore(password, username) < p.MinScore {
+		return validate.NewError("password", "too weak")
+	}
+
+	if p.Breached != nil {
+		h := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(h[:]))
+		suffixes, err := p.Breached.Range(ctx, hash[:5])
+		if err != nil {
+			return err
+		}
+		if suffixes[hash[5:]] {
+			return validate.NewError("password", "found in data breach")
+		}
+	}
+	return nil
+}
+
+// HashList is BreachRange from local list of breached passwords
+type HashList struct {
+	ranges map[string]map[string]bool
+}
+
+// LoadHashList loads hash list file, one uppercase SHA-1 hex per line
+// with optional ":count" suffix as in Pwned Passwords downloads
+func LoadHashList(name string) (*HashList, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := HashList{ranges: make(map[string]map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if len(line) != sha1.Size*2 {
+			continue
+		}
+		line = strings.ToUpper(line)
+
+		prefix := line[:5]
+		if l.ranges[prefix] == nil {
+			l.ranges[prefix] = make(map[string]bool)
+		}
+		l.ranges[prefix][line[5:]] = true
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Range implements BreachRange
+func (l *HashList) Range(ctx context.Context, prefix string) (map[string]bool, error) {
+	return l.ranges[strings.ToUpper(prefix)], nil
+}
+
+// commonPasswords are words counted as a single guess when found in password
+var commonPasswords = []string{
+	"password", "qwerty", "letmein", "welcome", "admin", "login",
+	"monkey", "dragon", "master", "sunshine", "iloveyou", "princess",
+	"football", "baseball", "shadow", "superman", "batman", "trustno1",
+	"secret", "abc123", "wongnok", "bangkok", "thailand",
+}
+
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t",
+	"@", "a", "$", "s", "!", "i",
+)
+
+// passwordScore estimates how hard password is to guess like zxcvbn's score,
+// 0: < 10^3, 1: < 10^6, 2: < 10^8, 3: < 10^10, 4: otherwise guesses
+func passwordScore(password string, userInputs ...string) int {
+	bits := passwordEntropy(password, userInputs)
+	switch {
+	case bits < 10:
+		return 0
+	case bits < 20:
+		return 1
+	case bits < 27:
+		return 2
+	case bits < 33:
+		return 3
+	}
+	return 4
+}
+
+// passwordEntropy returns log2 of estimated guesses,
+// dictionary words and user inputs cost one word guess,
+// repeats, sequences and keyboard walks cost only their length
+func passwordEntropy(password string, userInputs []string) float64 {
+	lower := strings.ToLower(password)
+	unleet := leetReplacer.Replace(lower)
+	covered := make([]bool, len(lower))
+
+	var bits float64
+	wordBits := math.Log2(float64(len(commonPasswords)+len(userInputs))) + 1
+	for _, words := range [][]string{commonPasswords, userInputs} {
+		for _, w := range words {
+			if len(w) < 3 {
+				continue
+			}
+			for _, s := range []string{lower, unleet} {
+				for i := 0; i+len(w) <= len(s); {
+					j := strings.Index(s[i:], w)
+					if j < 0 {
+						break
+					}
+					start := i + j
+					if !covered[start] {
+						bits += wordBits
+					}
+					for k := start; k < start+len(w); k++ {
+						covered[k] = true
+					}
+					i = start + len(w)
+				}
+			}
+		}
+	}
+
+	// pattern of n characters costs log2(n) bits after its first character
+	charBits := math.Log2(float64(charsetSize(password)))
+	run := 1
+	for i := 0; i < len(lower); i++ {
+		if covered[i] {
+			run = 1
+			continue
+		}
+		if i > 0 && !covered[i-1] && isPatternContinuation(lower[i-1], lower[i]) {
+			run++
+			bits += math.Log2(float64(run)) - math.Log2(float64(run-1))
+			continue
+		}
+		run = 1
+		bits += charBits
+	}
+	return bits
+}
+
+func isPatternContinuation(prev, c byte) bool {
+	d := int(c) - int(prev)
+	if d >= -1 && d <= 1 {
+		return true
+	}
+	for _, row := range keyboardRows {
+		i := strings.IndexByte(row, prev)
+		j := strings.IndexByte(row, c)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+func charsetSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for i := 0; i < len(password); i++ {
+		c := password[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < 0x80:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
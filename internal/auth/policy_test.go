@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+func Test_passwordScore(t *testing.T) {
+	cases := []struct {
+		Password string
+		Inputs   []string
+		Score    int
+	}{
+		{"123456", nil, 0},
+		{"aaaaaaaa", nil, 0},
+		{"password", nil, 0},
+		{"p@ssw0rd", nil, 0},
+		{"qwerty123", nil, 1},
+		{"tester2", []string{"tester"}, 1},
+		{"kx9Tf", nil, 3},
+		{"correcthorsebatterystaple", nil, 4},
+		{"vT7#qL2!mZ", nil, 4},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Password, func(t *testing.T) {
+			assert.Equal(t, tC.Score, passwordScore(tC.Password, tC.Inputs...))
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wongnok-auth")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// SHA-1 of "correcthorsebatterystaple"
+	name := filepath.Join(dir, "breached.txt")
+	err = ioutil.WriteFile(name, []byte("BFD3617727EAB0E800E62A776C76381DEFBC4145:1234\n"), 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+	breached, err := LoadHashList(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := &PasswordPolicy{MinScore: 2, Breached: breached}
+	cases := []struct {
+		Name     string
+		Username string
+		Password string
+		Error    string
+	}{
+		{"Strong", "tester", "vT7#qL2!mZ", ""},
+		{"Weak", "tester", "qwerty123", "too weak"},
+		{"Contains username", "tester", "myTester#8812", "must not contain username"},
+		{"Breached", "tester", "correcthorsebatterystaple", "found in data breach"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			err := p.validate(context.Background(), tC.Username, tC.Password)
+			if tC.Error == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &validate.Error{}, err) {
+				assert.Contains(t, err.Error(), tC.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Identity is an user's identity from external provider
+type Identity struct {
+	Provider string
+	Subject  string
+	Email    string
+	Name     string
+}
+
+// Provider is an external identity provider using OAuth2 authorization code flow
+type Provider interface {
+	Name() string
+	AuthCodeURL(state, codeChallenge string) string
+	Exchange(ctx context.Context, code, codeVerifier string) (*Identity, error)
+}
+
+// OAuth2Provider is a generic OAuth2/OpenID Connect provider,
+// user's identity is retrieved from userinfo endpoint
+type OAuth2Provider struct {
+	ProviderName string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	RedirectURL  string
+	Scopes       []string
+
+	// Client is the http client used to call provider, default to http.DefaultClient
+	Client *http.Client
+}
+
+// GoogleProvider creates Google's OpenID Connect provider
+func GoogleProvider(clientID, clientSecret, redirectURL string) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// FacebookProvider creates Facebook's OAuth2 provider
+func FacebookProvider(clientID, clientSecret, redirectURL string) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "facebook",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://www.facebook.com/v3.2/dialog/oauth",
+		TokenURL:     "https://graph.facebook.com/v3.2/oauth/access_token",
+		UserInfoURL:  "https://graph.facebook.com/v3.2/me?fields=id,name,email",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email"},
+	}
+}
+
+// LINEProvider creates LINE's OpenID Connect provider
+func LINEProvider(clientID, clientSecret, redirectURL string) *OAuth2Provider {
+	return &OAuth2Provider{
+		ProviderName: "line",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://access.line.me/oauth2/v2.1/authorize",
+		TokenURL:     "https://api.line.me/oauth2/v2.1/token",
+		UserInfoURL:  "https://api.line.me/oauth2/v2.1/userinfo",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "profile", "email"},
+	}
+}
+
+// Name returns provider's name
+func (p *OAuth2Provider) Name() string {
+	return p.ProviderName
+}
+
+func (p *OAuth2Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+// AuthCodeURL returns url to redirect user to provider's consent page
+func (p *OAuth2Provider) AuthCodeURL(state, codeChallenge string) string {
+	q := make(url.Values)
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode()
+}
+
+// Exchange exchanges authorization code for user's identity
+func (p *OAuth2Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Identity, error) {
+	form := make(url.Values)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	err = p.do(req, &tokenResp)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("auth: %s: empty access token", p.ProviderName)
+	}
+
+	req, err = http.NewRequest("GET", p.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var info struct {
+		Sub   string `json:"sub"`
+		ID    string `json:"id"`
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	err = p.do(req, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenID Connect uses sub, Facebook uses id
+	subject := info.Sub
+	if subject == "" {
+		subject = info.ID
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("auth: %s: empty subject", p.ProviderName)
+	}
+
+	return &Identity{
+		Provider: p.ProviderName,
+		Subject:  subject,
+		Email:    info.Email,
+		Name:     info.Name,
+	}, nil
+}
+
+func (p *OAuth2Provider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: %s: unexpected status %d from %s", p.ProviderName, resp.StatusCode, req.URL.Path)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// generateCodeVerifier generates PKCE's code verifier (RFC 7636)
+func generateCodeVerifier() string {
+	return generateRandomString(32)
+}
+
+// codeChallenge returns S256 code challenge for the verifier
+func codeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeOIDCServer creates fake provider which accepts code "valid-code"
+func newFakeOIDCServer(t *testing.T) *httptest.Server {
+	challenges := make(map[string]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		challenges["valid-code"] = r.FormValue("code_challenge")
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "authorization_code", r.FormValue("grant_type"))
+		assert.Equal(t, "client-id", r.FormValue("client_id"))
+		assert.Equal(t, "client-secret", r.FormValue("client_secret"))
+
+		code := r.FormValue("code")
+		challenge, ok := challenges[code]
+		if !ok || codeChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"sub":   "12345",
+			"email": "tester@example.com",
+			"name":  "Tester",
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestOAuth2Provider(t *testing.T) {
+	srv := newFakeOIDCServer(t)
+	defer srv.Close()
+
+	p := &OAuth2Provider{
+		ProviderName: "fake",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		AuthURL:      srv.URL + "/authorize",
+		TokenURL:     srv.URL + "/token",
+		UserInfoURL:  srv.URL + "/userinfo",
+		RedirectURL:  "http://localhost/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+	verifier := generateCodeVerifier()
+
+	authURL := p.AuthCodeURL("state-1", codeChallenge(verifier))
+	u, err := url.Parse(authURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	q := u.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "state-1", q.Get("state"))
+	assert.Equal(t, "openid email", q.Get("scope"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "http://localhost/callback", q.Get("redirect_uri"))
+
+	// user consents
+	resp, err := http.Get(authURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	t.Run("Success", func(t *testing.T) {
+		identity, err := p.Exchange(bgCtx, "valid-code", verifier)
+		assert.NoError(t, err)
+		assert.Equal(t, &Identity{
+			Provider: "fake",
+			Subject:  "12345",
+			Email:    "tester@example.com",
+			Name:     "Tester",
+		}, identity)
+	})
+
+	t.Run("Invalid verifier", func(t *testing.T) {
+		_, err := p.Exchange(bgCtx, "valid-code", generateCodeVerifier())
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		_, err := p.Exchange(bgCtx, "invalid-code", verifier)
+		assert.Error(t, err)
+	})
+}
+
+func Test_codeChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	assert.Equal(t,
+		"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"),
+	)
+}
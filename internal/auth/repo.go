@@ -10,6 +10,15 @@ import (
 type repo struct{}
 
 func (repo) InsertUser(ctx context.Context, db *sql.DB, username, password string) (userID int64, err error) {
+	return insertUser(ctx, db, username, password)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertUser inserts user using db or transaction
+func insertUser(ctx context.Context, db queryRower, username, password string) (userID int64, err error) {
 	err = db.QueryRowContext(ctx, `
 		insert into users
 			(username, password)
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// Permissions
+const (
+	PermissionShopCreate     = "shop.create"
+	PermissionShopManage     = "shop.manage"
+	PermissionTaxonomyManage = "taxonomy.manage"
+	PermissionClaimReview    = "claim.review"
+	PermissionReviewModerate = "review.moderate"
+	PermissionUserManage     = "user.manage"
+)
+
+// AllPermissions lists all known permissions
+var AllPermissions = []string{
+	PermissionShopCreate,
+	PermissionShopManage,
+	PermissionTaxonomyManage,
+	PermissionClaimReview,
+	PermissionReviewModerate,
+	PermissionUserManage,
+}
+
+func isPermission(p string) bool {
+	for _, x := range AllPermissions {
+		if x == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Role is a named set of permissions
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	CreatedAt   time.Time
+}
+
+var reRoleName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ListRoles retrieves all roles
+func (svc *Auth) ListRoles(ctx context.Context) ([]*Role, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			roles.name, roles.description, roles.created_at,
+			coalesce(array_agg(role_permissions.permission order by role_permissions.permission)
+				filter (where role_permissions.permission is not null), '{}')
+		from roles
+		left join role_permissions on role_permissions.role = roles.name
+		group by roles.name
+		order by roles.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		var role Role
+		err = rows.Scan(
+			&role.Name, &role.Description, &role.CreatedAt,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SaveRole creates or replaces role
+func (svc *Auth) SaveRole(ctx context.Context, name, description string, permissions []string) error {
+	name = strings.ToLower(name)
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return validate.NewRequiredError("name")
+	}
+	if len(name) > 32 {
+		return validate.NewError("name", "too long")
+	}
+	if !reRoleName.MatchString(name) {
+		return validate.NewError("name", "invalid")
+	}
+	if len(description) > 200 {
+		return validate.NewError("description", "too long")
+	}
+	for _, p := range permissions {
+		if !isPermission(p) {
+			return validate.NewError("permissions", "unknown permission "+p)
+		}
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		insert into roles
+			(name, description)
+		values
+			($1, $2)
+		on conflict (name) do update
+		set description = excluded.description
+	`, name, description)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from role_permissions
+		where role = $1
+	`, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into role_permissions
+			(role, permission)
+		select $1, unnest($2::varchar[])
+		on conflict do nothing
+	`, name, pq.Array(permissions))
+	if err != nil {
+		return err
+	}
+
+	// role's permissions changed for every member
+	err = notifyCache(ctx, tx, invalidateAll)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	svc.cache.invalidate(invalidateAll)
+	return nil
+}
+
+// SetUserRoles replaces user's roles
+func (svc *Auth) SetUserRoles(ctx context.Context, userID int64, roles []string) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		select exists (select 1 from users where id = $1)
+	`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	var known int
+	err = tx.QueryRowContext(ctx, `
+		select count(*)
+		from roles
+		where name = any($1)
+	`, pq.Array(roles)).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known != len(uniqueStrings(roles)) {
+		return validate.NewError("roles", "unknown role")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from user_roles
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into user_roles
+			(user_id, role)
+		select $1, unnest($2::varchar[])
+		on conflict do nothing
+	`, userID, pq.Array(roles))
+	if err != nil {
+		return err
+	}
+
+	err = notifyCache(ctx, tx, invalidateUser(userID))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	svc.cache.invalidate(invalidateUser(userID))
+	return nil
+}
+
+// uniqueStrings returns xs without duplicates, never nil
+// so it can be stored into not null array column
+func uniqueStrings(xs []string) []string {
+	seen := make(map[string]bool, len(xs))
+	r := make([]string, 0, len(xs))
+	for _, x := range xs {
+		if !seen[x] {
+			seen[x] = true
+			r = append(r, x)
+		}
+	}
+	return r
+}
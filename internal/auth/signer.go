@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a signing key for access tokens
+type Key struct {
+	ID        string
+	Algorithm string
+
+	// Secret is used by HS256
+	Secret []byte
+
+	// PrivateKey is used by EdDSA, only the current key needs private key
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+// NewHS256Key creates HMAC-SHA256 key
+func NewHS256Key(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: AlgHS256, Secret: secret}
+}
+
+// NewEdDSAKey creates Ed25519 key from 32 bytes seed
+func NewEdDSAKey(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("auth: invalid ed25519 seed size")
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	return &Key{
+		ID:         id,
+		Algorithm:  AlgEdDSA,
+		PrivateKey: priv,
+		PublicKey:  priv.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+func (key *Key) sign(data []byte) ([]byte, error) {
+	switch key.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case AlgEdDSA:
+		if len(key.PrivateKey) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("auth: key %s can not sign", key.ID)
+		}
+		return ed25519.Sign(key.PrivateKey, data), nil
+	}
+	return nil, fmt.Errorf("auth: unknown algorithm %s", key.Algorithm)
+}
+
+func (key *Key) verify(data, sig []byte) bool {
+	switch key.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(data)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case AlgEdDSA:
+		return len(key.PublicKey) == ed25519.PublicKeySize && ed25519.Verify(key.PublicKey, data, sig)
+	}
+	return false
+}
+
+// Signer signs and verifies stateless access tokens (JWT)
+type Signer struct {
+	current *Key
+	keys    map[string]*Key
+	ttl     time.Duration
+}
+
+// NewSigner creates new signer, tokens are signed by current key
+// and verified by current or any of old keys to support key rotation
+func NewSigner(ttl time.Duration, current *Key, old ...*Key) *Signer {
+	keys := map[string]*Key{current.ID: current}
+	for _, k := range old {
+		keys[k.ID] = k
+	}
+	return &Signer{current, keys, ttl}
+}
+
+// TTL returns access token's lifetime
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+type tokenClaims struct {
+	Subject     int64    `json:"sub,string"`
+	SessionID   string   `json:"sid"`
+	ID          string   `json:"jti"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	Permissions []string `json:"perms"`
+}
+
+// Errors from signed token verification
+var (
+	errMalformedToken = errors.New("auth: malformed token")
+	errInvalidSig     = errors.New("auth: invalid signature")
+)
+
+func (s *Signer) sign(claims *tokenClaims) (string, error) {
+	header, err := json.Marshal(tokenHeader{s.current.Algorithm, s.current.ID, "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signing := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sig, err := s.current.sign([]byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + enc.EncodeToString(sig), nil
+}
+
+func (s *Signer) verify(token string, now time.Time) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	enc := base64.RawURLEncoding
+	b, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var header tokenHeader
+	err = json.Unmarshal(b, &header)
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	// algorithm must match the key, never trust alg from token alone
+	key := s.keys[header.Kid]
+	if key == nil || key.Algorithm != header.Alg {
+		return nil, errInvalidSig
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, errInvalidSig
+	}
+
+	b, err = enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var claims tokenClaims
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// isSignedToken returns true if token looks like JWT,
+// session tokens are base64url without dot
+func isSignedToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// sessionID derives session id from session token,
+// access tokens refer to their session without exposing session token
+func sessionID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", h[:])
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEdDSAKey(t *testing.T, id string) *Key {
+	key, err := NewEdDSAKey(id, []byte(strings.Repeat("s", 32)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return key
+}
+
+func TestSigner(t *testing.T) {
+	now := time.Now()
+	claims := &tokenClaims{
+		Subject:     42,
+		SessionID:   sessionID("session"),
+		ID:          "jti",
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+		Permissions: []string{PermissionShopCreate},
+	}
+
+	cases := []struct {
+		Name string
+		key  *Key
+	}{
+		{"HS256", NewHS256Key("k1", []byte("secret"))},
+		{"EdDSA", testEdDSAKey(t, "k2")},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			s := NewSigner(time.Minute, tC.key)
+			token, err := s.sign(claims)
+			assert.NoError(t, err)
+			assert.True(t, isSignedToken(token))
+
+			got, err := s.verify(token, now)
+			assert.NoError(t, err)
+			assert.Equal(t, claims, got)
+
+			_, err = s.verify(token, now.Add(time.Minute))
+			assert.Equal(t, ErrTokenExpired, err)
+
+			_, err = s.verify(token[:len(token)-2]+"xx", now)
+			assert.Error(t, err)
+
+			parts := strings.Split(token, ".")
+			_, err = s.verify(parts[0]+"."+parts[0]+"."+parts[2], now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSigner_rotation(t *testing.T) {
+	now := time.Now()
+	claims := &tokenClaims{Subject: 1, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	oldKey := NewHS256Key("old", []byte("old secret"))
+	token, err := NewSigner(time.Minute, oldKey).sign(claims)
+	assert.NoError(t, err)
+
+	// rotated, old key still verifies
+	s := NewSigner(time.Minute, testEdDSAKey(t, "new"), oldKey)
+	_, err = s.verify(token, now)
+	assert.NoError(t, err)
+
+	// old key removed
+	s = NewSigner(time.Minute, testEdDSAKey(t, "new"))
+	_, err = s.verify(token, now)
+	assert.Equal(t, errInvalidSig, err)
+}
+
+func TestSigner_algorithmMismatch(t *testing.T) {
+	now := time.Now()
+	claims := &tokenClaims{Subject: 1, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	// token signed with HS256 using public key as secret must not verify with EdDSA key
+	edKey := testEdDSAKey(t, "k")
+	token, err := NewSigner(time.Minute, NewHS256Key("k", edKey.PublicKey)).sign(claims)
+	assert.NoError(t, err)
+
+	_, err = NewSigner(time.Minute, edKey).verify(token, now)
+	assert.Equal(t, errInvalidSig, err)
+}
+
+func TestRevocationList(t *testing.T) {
+	now := time.Now()
+	l := newRevocationList()
+	assert.False(t, l.has("a", now))
+
+	l.add("a", now.Add(time.Minute))
+	assert.True(t, l.has("a", now))
+	assert.False(t, l.has("a", now.Add(time.Minute)))
+
+	l.replace(map[string]time.Time{"b": now.Add(time.Minute)})
+	assert.False(t, l.has("a", now))
+	assert.True(t, l.has("b", now))
+}
+
+func Test_isSignedToken(t *testing.T) {
+	assert.False(t, isSignedToken(generateToken()))
+	assert.True(t, isSignedToken("a.b.c"))
+}
@@ -6,7 +6,20 @@ import (
 )
 
 func generateToken() string {
-	b := make([]byte, 16)
+	return generateRandomString(16)
+}
+
+func generateRandomString(n int) string {
+	b := make([]byte, n)
 	rand.Read(b)
 	return base64.RawURLEncoding.EncodeToString(b)
 }
+
+func generateDigits(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = '0' + b[i]%10
+	}
+	return string(b)
+}
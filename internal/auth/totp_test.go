@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_hotp(t *testing.T) {
+	// RFC 6238 Appendix B, SHA1, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		Time int64
+		Code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tC := range cases {
+		assert.Equal(t, tC.Code, hotp(key, totpCounter(time.Unix(tC.Time, 0))))
+	}
+}
+
+func Test_verifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	t.Run("Success", func(t *testing.T) {
+		counter, ok := verifyTOTP(secret, "081804", now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, totpCounter(now), counter)
+	})
+
+	t.Run("Clock skew", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second), 0)
+		assert.True(t, ok)
+		_, ok = verifyTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second), 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, "081804", now, totpCounter(now))
+		assert.False(t, ok)
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, "000000", now, 0)
+		assert.False(t, ok)
+		_, ok = verifyTOTP(secret, "", now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("Invalid secret", func(t *testing.T) {
+		_, ok := verifyTOTP("!!!", "081804", now, 0)
+		assert.False(t, ok)
+	})
+}
+
+func Test_totpURI(t *testing.T) {
+	assert.Equal(t,
+		"otpauth://totp/Wongnok:tester?algorithm=SHA1&digits=6&issuer=Wongnok&period=30&secret=ABC",
+		totpURI("tester", "ABC"),
+	)
+}
+
+func Test_generateRecoveryCode(t *testing.T) {
+	code := generateRecoveryCode()
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, code)
+	assert.NotEqual(t, code, generateRecoveryCode())
+	assert.Equal(t, hashRecoveryCode(code), hashRecoveryCode(" "+code[:5]+code[6:]+" "))
+}
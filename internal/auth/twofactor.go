@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	recoveryCodeCount = 10
+
+	// challengeTTL is the duration user has to complete second factor
+	challengeTTL = 5 * time.Minute
+
+	// challengeMaxAttempts limits guessing codes on a challenge
+	challengeMaxAttempts = 5
+
+	// twoFactorMaxFailures limits guessing codes across challenges,
+	// since every sign in creates new challenge
+	twoFactorMaxFailures = 10
+
+	// twoFactorFailureWindow is the duration failures count toward lockout
+	twoFactorFailureWindow = 15 * time.Minute
+)
+
+// SignInResult type, either Token or Challenge is set
+type SignInResult struct {
+	Token string
+
+	// Challenge is set when user requires second factor,
+	// use VerifyTwoFactor to complete sign in
+	Challenge string
+}
+
+func generateRecoveryCode() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+	s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:]
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.TrimSpace(code)
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// completeSignIn creates session token, or challenge if user enabled two-factor
+func (svc *Auth) completeSignIn(ctx context.Context, userID int64) (*SignInResult, error) {
+	var enabled bool
+	err := svc.db.QueryRowContext(ctx, `
+		select totp_enabled
+		from users
+		where id = $1
+	`, userID).Scan(&enabled)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enabled {
+		token, err := svc.createToken(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &SignInResult{Token: token}, nil
+	}
+
+	challenge := generateToken()
+	_, err = svc.db.ExecContext(ctx, `
+		insert into auth_challenges
+			(id, user_id)
+		values
+			($1, $2)
+	`, challenge, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &SignInResult{Challenge: challenge}, nil
+}
+
+// VerifyTwoFactor completes sign in challenge using TOTP or recovery code
+func (svc *Auth) VerifyTwoFactor(ctx context.Context, challenge, code string) (token string, err error) {
+	if challenge == "" {
+		return "", ErrInvalidChallenge
+	}
+	if code == "" {
+		return "", ErrInvalidCode
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, `
+		update auth_challenges
+		set attempts = attempts + 1
+		where id = $1 and created_at > $2 and attempts < $3
+		returning user_id
+	`, challenge, time.Now().Add(-challengeTTL), challengeMaxAttempts).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+
+	failures, err := countTwoFactorFailures(ctx, tx, userID)
+	if err != nil {
+		return "", err
+	}
+	if failures >= twoFactorMaxFailures {
+		return "", ErrTwoFactorLocked
+	}
+
+	ok, err := svc.verifySecondFactor(ctx, tx, userID, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		// keep attempts counter
+		_, err = tx.ExecContext(ctx, `
+			insert into two_factor_failures
+				(user_id)
+			values
+				($1)
+		`, userID)
+		if err != nil {
+			return "", err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return "", err
+		}
+		return "", ErrInvalidCode
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from auth_challenges
+		where id = $1
+	`, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from two_factor_failures
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return svc.createToken(ctx, userID)
+}
+
+// countTwoFactorFailures locks user's row to serialize verifications,
+// then counts user's failures in window and removes expired ones
+func countTwoFactorFailures(ctx context.Context, tx *sql.Tx, userID int64) (int, error) {
+	_, err := tx.ExecContext(ctx, `
+		select 1
+		from users
+		where id = $1
+		for update
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	since := time.Now().Add(-twoFactorFailureWindow)
+	_, err = tx.ExecContext(ctx, `
+		delete from two_factor_failures
+		where user_id = $1 and created_at <= $2
+	`, userID, since)
+	if err != nil {
+		return 0, err
+	}
+
+	var cnt int
+	err = tx.QueryRowContext(ctx, `
+		select count(*)
+		from two_factor_failures
+		where user_id = $1
+	`, userID).Scan(&cnt)
+	if err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// verifySecondFactor verifies TOTP code or consumes recovery code
+func (svc *Auth) verifySecondFactor(ctx context.Context, tx *sql.Tx, userID int64, code string) (bool, error) {
+	var (
+		secret      string
+		lastCounter int64
+	)
+	err := tx.QueryRowContext(ctx, `
+		select totp_secret, totp_last_counter
+		from users
+		where id = $1 and totp_enabled
+		for update
+	`, userID).Scan(&secret, &lastCounter)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if counter, ok := verifyTOTP(secret, code, time.Now(), uint64(lastCounter)); ok {
+		_, err = tx.ExecContext(ctx, `
+			update users
+			set totp_last_counter = $2
+			where id = $1
+		`, userID, int64(counter))
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		update recovery_codes
+		set used_at = now()
+		where user_id = $1 and code_hash = $2 and used_at is null
+	`, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// EnrollTwoFactor generates new TOTP secret for user,
+// two-factor will be enabled after ConfirmTwoFactor
+func (svc *Auth) EnrollTwoFactor(ctx context.Context, userID int64) (secret, uri string, err error) {
+	secret = generateTOTPSecret()
+
+	var username string
+	err = svc.db.QueryRowContext(ctx, `
+		update users
+		set
+			totp_secret = $2,
+			totp_last_counter = 0
+		where id = $1 and not totp_enabled
+		returning username
+	`, userID, secret).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", "", ErrTwoFactorEnabled
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, totpURI(username, secret), nil
+}
+
+// ConfirmTwoFactor enables two-factor after user proves the secret
+// and returns recovery codes, recovery codes can not be retrieved again
+func (svc *Auth) ConfirmTwoFactor(ctx context.Context, userID int64, code string) (recoveryCodes []string, err error) {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		secret  string
+		enabled bool
+	)
+	err = tx.QueryRowContext(ctx, `
+		select totp_secret, totp_enabled
+		from users
+		where id = $1
+		for update
+	`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if secret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	counter, ok := verifyTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update users
+		set
+			totp_enabled = true,
+			totp_last_counter = $2
+		where id = $1
+	`, userID, int64(counter))
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes = make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := generateRecoveryCode()
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from recovery_codes
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into recovery_codes
+			(user_id, code_hash)
+		select $1, unnest($2::varchar[])
+	`, userID, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	// permissions may depend on two-factor policy
+	err = notifyCache(ctx, tx, invalidateUser(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	svc.cache.invalidate(invalidateUser(userID))
+	return recoveryCodes, nil
+}
+
+// DisableTwoFactor disables two-factor using TOTP or recovery code
+func (svc *Auth) DisableTwoFactor(ctx context.Context, userID int64, code string) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ok, err := svc.verifySecondFactor(ctx, tx, userID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update users
+		set
+			totp_enabled = false,
+			totp_secret = '',
+			totp_last_counter = 0
+		where id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from recovery_codes
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = notifyCache(ctx, tx, invalidateUser(userID))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	svc.cache.invalidate(invalidateUser(userID))
+	return nil
+}
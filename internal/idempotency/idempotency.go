@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Errors
+var (
+	ErrInProgress = errors.New("idempotency: request with the same key is in progress")
+	ErrKeyReused  = errors.New("idempotency: key was used with different request")
+)
+
+// LockTimeout is how long a key stays in progress,
+// after that the request is considered abandoned and the key can be used again
+const LockTimeout = time.Minute
+
+// Response is a stored response to replay
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Store stores requests' keys and their responses
+type Store interface {
+	// Begin locks key for request with fingerprint until Complete or Release,
+	// returns stored response when the key was completed,
+	// ErrKeyReused when key was used with different fingerprint,
+	// or ErrInProgress when another request holds the key.
+	// Key expires ttl after Begin
+	Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Response, error)
+
+	// Complete stores response for locked key
+	Complete(ctx context.Context, key string, resp *Response) error
+
+	// Release unlocks key without response, lets client retry
+	Release(ctx context.Context, key string) error
+}
+
+// record is key's state
+type record struct {
+	Fingerprint string
+	Response    *Response
+	LockedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// begin returns stored response or error for existing record,
+// ok is true when the record can be replaced by new request
+func (r *record) begin(fingerprint string, now time.Time) (resp *Response, ok bool, err error) {
+	if !now.Before(r.ExpiresAt) {
+		return nil, true, nil
+	}
+	if r.Fingerprint != fingerprint {
+		return nil, false, ErrKeyReused
+	}
+	if r.Response != nil {
+		return r.Response, false, nil
+	}
+	if now.Sub(r.LockedAt) >= LockTimeout {
+		// abandoned, ex. server crashed
+		return nil, true, nil
+	}
+	return nil, false, ErrInProgress
+}
@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	ttl := time.Hour
+	s := NewMemoryStore()
+
+	resp, err := s.Begin(ctx, "a", "f1", now, ttl)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// concurrent duplicate
+	_, err = s.Begin(ctx, "a", "f1", now, ttl)
+	assert.Equal(t, ErrInProgress, err)
+
+	// different request
+	_, err = s.Begin(ctx, "a", "f2", now, ttl)
+	assert.Equal(t, ErrKeyReused, err)
+
+	stored := &Response{StatusCode: 200, Body: []byte(`{"id":1}`)}
+	assert.NoError(t, s.Complete(ctx, "a", stored))
+
+	resp, err = s.Begin(ctx, "a", "f1", now.Add(time.Second), ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, resp)
+
+	// completed key can not be released
+	assert.NoError(t, s.Release(ctx, "a"))
+	resp, _ = s.Begin(ctx, "a", "f1", now.Add(time.Second), ttl)
+	assert.Equal(t, stored, resp)
+
+	// expired
+	resp, err = s.Begin(ctx, "a", "f2", now.Add(ttl), ttl)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestMemoryStore_Release(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryStore()
+
+	s.Begin(ctx, "a", "f1", now, time.Hour)
+	assert.NoError(t, s.Release(ctx, "a"))
+
+	resp, err := s.Begin(ctx, "a", "f2", now, time.Hour)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestMemoryStore_Abandoned(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryStore()
+
+	s.Begin(ctx, "a", "f1", now, time.Hour)
+
+	_, err := s.Begin(ctx, "a", "f1", now.Add(LockTimeout-time.Second), time.Hour)
+	assert.Equal(t, ErrInProgress, err)
+
+	resp, err := s.Begin(ctx, "a", "f1", now.Add(LockTimeout), time.Hour)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+}
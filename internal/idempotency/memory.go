@@ -0,0 +1,76 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often memory store drops expired keys
+const sweepInterval = time.Minute
+
+// MemoryStore stores keys in memory, for single replica deployments and tests
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*record
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*record)}
+}
+
+// Begin implements Store
+func (s *MemoryStore) Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	if r := s.records[key]; r != nil {
+		resp, ok, err := r.begin(fingerprint, now)
+		if !ok {
+			return resp, err
+		}
+	}
+	s.records[key] = &record{
+		Fingerprint: fingerprint,
+		LockedAt:    now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	return nil, nil
+}
+
+// Complete implements Store
+func (s *MemoryStore) Complete(ctx context.Context, key string, resp *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r := s.records[key]; r != nil {
+		r.Response = resp
+	}
+	return nil
+}
+
+// Release implements Store
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r := s.records[key]; r != nil && r.Response == nil {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, r := range s.records {
+		if !now.Before(r.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	s.lastSweep = now
+}
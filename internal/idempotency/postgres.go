@@ -0,0 +1,144 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// PostgresStore stores keys in postgres, for multi-replica deployments
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates new postgres store
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// Begin implements Store
+func (s *PostgresStore) Begin(ctx context.Context, key, fingerprint string, now time.Time, ttl time.Duration) (*Response, error) {
+	// timestamp column drops time zone, store as utc
+	now = now.UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		insert into idempotency_keys
+			(key, fingerprint, locked_at, expires_at)
+		values
+			($1, $2, $3, $4)
+		on conflict (key) do nothing
+	`, key, fingerprint, now, now.Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, tx.Commit()
+	}
+
+	// key exists, concurrent request waits for the other to commit its lock
+	var (
+		r          record
+		statusCode sql.NullInt64
+		header     []byte
+		body       []byte
+	)
+	err = tx.QueryRowContext(ctx, `
+		select fingerprint, status_code, header, body, locked_at, expires_at
+		from idempotency_keys
+		where key = $1
+		for update
+	`, key).Scan(&r.Fingerprint, &statusCode, &header, &body, &r.LockedAt, &r.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode.Valid {
+		r.Response = &Response{StatusCode: int(statusCode.Int64), Body: body}
+		err = json.Unmarshal(header, &r.Response.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, ok, err := r.begin(fingerprint, now)
+	if !ok {
+		return resp, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update idempotency_keys
+		set fingerprint = $2,
+		    status_code = null,
+		    header = null,
+		    body = null,
+		    locked_at = $3,
+		    expires_at = $4
+		where key = $1
+	`, key, fingerprint, now, now.Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// Complete implements Store
+func (s *PostgresStore) Complete(ctx context.Context, key string, resp *Response) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		update idempotency_keys
+		set status_code = $2, header = $3, body = $4
+		where key = $1
+	`, key, resp.StatusCode, header, resp.Body)
+	return err
+}
+
+// Release implements Store
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `
+		delete from idempotency_keys
+		where key = $1 and status_code is null
+	`, key)
+	return err
+}
+
+// Purge deletes keys expired before
+func (s *PostgresStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		delete from idempotency_keys
+		where expires_at < $1
+	`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunPurgeJob purges expired keys every interval until ctx is canceled
+func (s *PostgresStore) RunPurgeJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := s.Purge(ctx, time.Now())
+		if err != nil {
+			log.Println("idempotency: purge error;", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
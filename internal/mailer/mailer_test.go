@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := FileMailer{Dir: dir, From: "noreply@wongnok.local"}
+	err = m.Send(context.Background(), &Message{
+		To:      "tester@example.com",
+		Subject: "ยืนยันอีเมล",
+		Body:    "hello\nworld",
+	})
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*-tester@example.com.eml"))
+	if assert.Len(t, files, 1) {
+		b, _ := ioutil.ReadFile(files[0])
+		assert.Contains(t, string(b), "To: tester@example.com\r\n")
+		assert.Contains(t, string(b), "Subject: =?utf-8?q?")
+		assert.Contains(t, string(b), "\r\n\r\nhello\r\nworld")
+	}
+}
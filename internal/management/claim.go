@@ -0,0 +1,261 @@
+package management
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// ClaimStatus type
+type ClaimStatus string
+
+// Claim statuses
+const (
+	ClaimPending  ClaimStatus = "pending"
+	ClaimApproved ClaimStatus = "approved"
+	ClaimRejected ClaimStatus = "rejected"
+)
+
+// Valid returns true if status is known
+func (status ClaimStatus) Valid() bool {
+	switch status {
+	case ClaimPending, ClaimApproved, ClaimRejected:
+		return true
+	}
+	return false
+}
+
+// Claim is an user's request to become shop's owner
+type Claim struct {
+	ID           int64
+	ShopID       int64
+	UserID       int64
+	Evidence     string
+	Status       ClaimStatus
+	RejectReason string
+	ReviewedBy   int64
+	CreatedAt    time.Time
+	ReviewedAt   time.Time
+}
+
+// CreateClaim submits shop ownership claim
+func (svc *Management) CreateClaim(ctx context.Context, shopID, userID int64, evidence string) (claimID int64, err error) {
+	evidence = strings.TrimSpace(evidence)
+	if evidence == "" {
+		return 0, validate.NewRequiredError("evidence")
+	}
+	if utf8.RuneCountInString(evidence) > 2000 {
+		return 0, validate.NewError("evidence", "too long")
+	}
+
+	isOwner, err := svc.IsShopOwner(ctx, shopID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if isOwner {
+		return 0, validate.NewError("shop", "already owned")
+	}
+
+	err = svc.db.QueryRowContext(ctx, `
+		insert into shop_claims
+			(shop_id, user_id, evidence, status)
+		values
+			($1, $2, $3, $4)
+		returning id
+	`, shopID, userID, evidence, ClaimPending).Scan(&claimID)
+	if err, ok := err.(*pq.Error); ok {
+		if err.Code == "23503" && err.Constraint == "shop_claims_shop_id_fkey" {
+			return 0, ErrShopNotFound
+		}
+		if err.Code == "23505" && err.Constraint == "shop_claims_pending_idx" {
+			return 0, validate.NewError("shop", "claim already pending")
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return claimID, nil
+}
+
+// ListClaims retrieves claims of given status, oldest first
+func (svc *Management) ListClaims(ctx context.Context, status ClaimStatus) ([]*Claim, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			id, shop_id, user_id, evidence, status,
+			reject_reason, reviewed_by, created_at,
+			reviewed_at
+		from shop_claims
+		where status = $1
+		order by id
+	`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []*Claim
+	for rows.Next() {
+		var (
+			claim      Claim
+			reviewedBy *int64
+			reviewedAt *time.Time
+		)
+		err = rows.Scan(
+			&claim.ID, &claim.ShopID, &claim.UserID, &claim.Evidence, &claim.Status,
+			&claim.RejectReason, &reviewedBy, &claim.CreatedAt,
+			&reviewedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if reviewedBy != nil {
+			claim.ReviewedBy = *reviewedBy
+		}
+		if reviewedAt != nil {
+			claim.ReviewedAt = *reviewedAt
+		}
+
+		claims = append(claims, &claim)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// reviewClaim marks pending claim as reviewed and returns it
+func reviewClaim(ctx context.Context, tx *sql.Tx, claimID, reviewerID int64, status ClaimStatus, reason string) (*Claim, error) {
+	var claim Claim
+	err := tx.QueryRowContext(ctx, `
+		select
+			id, shop_id, user_id, status
+		from shop_claims
+		where id = $1
+		for update
+	`, claimID).Scan(&claim.ID, &claim.ShopID, &claim.UserID, &claim.Status)
+	if err == sql.ErrNoRows {
+		return nil, ErrClaimNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claim.Status != ClaimPending {
+		return nil, ErrClaimNotPending
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update shop_claims
+		set
+			status = $2,
+			reject_reason = $3,
+			reviewed_by = $4,
+			reviewed_at = now()
+		where id = $1
+	`, claimID, status, reason, reviewerID)
+	if err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// ApproveClaim approves pending claim and adds claimer as shop's owner
+func (svc *Management) ApproveClaim(ctx context.Context, claimID, reviewerID int64) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	claim, err := reviewClaim(ctx, tx, claimID, reviewerID, ClaimApproved, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		insert into shop_owners
+			(shop_id, user_id)
+		values
+			($1, $2)
+		on conflict do nothing
+	`, claim.ShopID, claim.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RejectClaim rejects pending claim
+func (svc *Management) RejectClaim(ctx context.Context, claimID, reviewerID int64, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > 500 {
+		return validate.NewError("reason", "too long")
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = reviewClaim(ctx, tx, claimID, reviewerID, ClaimRejected, reason)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsShopOwner returns true if user is an owner of the shop
+func (svc *Management) IsShopOwner(ctx context.Context, shopID, userID int64) (bool, error) {
+	var isOwner bool
+	err := svc.db.QueryRowContext(ctx, `
+		select exists (
+			select 1
+			from shop_owners
+			where shop_id = $1 and user_id = $2
+		)
+	`, shopID, userID).Scan(&isOwner)
+	if err != nil {
+		return false, err
+	}
+	return isOwner, nil
+}
+
+// ListOwnedShops retrieves shops owned by user
+func (svc *Management) ListOwnedShops(ctx context.Context, userID int64) ([]*Shop, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select `+shopColumns+`
+		from shops
+		where id in (select shop_id from shop_owners where user_id = $1)
+		order by id desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shops []*Shop
+	for rows.Next() {
+		shop, err := scanShop(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		shops = append(shops, shop)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return shops, nil
+}
@@ -0,0 +1,16 @@
+package management
+
+import (
+	"errors"
+)
+
+// Errors
+var (
+	ErrShopNotFound        = errors.New("management: shop not found")
+	ErrShopVersionMismatch = errors.New("management: shop version mismatch")
+	ErrTermNotFound        = errors.New("management: term not found")
+
+	ErrClaimNotFound   = errors.New("management: claim not found")
+	ErrClaimNotPending = errors.New("management: claim not pending")
+	ErrReviewNotFound  = errors.New("management: review not found")
+)
@@ -0,0 +1,291 @@
+package management
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	// timezones must load the same on every server, validated timezones never fail to load
+	_ "time/tzdata"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// DefaultTimezone is the timezone used when shop does not set one
+const DefaultTimezone = "Asia/Bangkok"
+
+// Interval is an opening interval within a day in "15:04" format.
+// Interval closes on the next day when Close is not after Open,
+// "24:00" can be used as Close to open until midnight.
+type Interval struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// SpecialDay overrides weekly schedule on a date, ex. holidays
+type SpecialDay struct {
+	Date      string      `json:"date"` // 2006-01-02
+	Closed    bool        `json:"closed"`
+	Intervals []*Interval `json:"intervals"`
+	Note      string      `json:"note"`
+}
+
+// OpeningHours is the shop's weekly schedule
+type OpeningHours struct {
+	// Weekly is indexed by time.Weekday
+	Weekly   [7][]*Interval `json:"weekly"`
+	Specials []*SpecialDay  `json:"specials"`
+}
+
+func parseClock(s string, allowMidnight bool) (minutes int, ok bool) {
+	if allowMidnight && s == "24:00" {
+		return 24 * 60, true
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func (iv *Interval) minutes() (open, close int) {
+	open, _ = parseClock(iv.Open, false)
+	close, _ = parseClock(iv.Close, true)
+	return
+}
+
+func (iv *Interval) overnight() bool {
+	open, close := iv.minutes()
+	return close <= open
+}
+
+// intervalsOn returns intervals which start on given date
+func (hours *OpeningHours) intervalsOn(date time.Time) []*Interval {
+	d := date.Format("2006-01-02")
+	for _, sp := range hours.Specials {
+		if sp.Date == d {
+			if sp.Closed {
+				return nil
+			}
+			return sp.Intervals
+		}
+	}
+	return hours.Weekly[date.Weekday()]
+}
+
+func validateIntervals(field string, intervals []*Interval) error {
+	if len(intervals) > 5 {
+		return validate.NewError(field, "limit to 5 intervals")
+	}
+
+	type span struct{ start, end int }
+	spans := make([]span, 0, len(intervals))
+	for i, iv := range intervals {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if iv == nil {
+			return validate.NewRequiredError(f)
+		}
+		open, ok := parseClock(iv.Open, false)
+		if !ok {
+			return validate.NewError(f+".open", "invalid time")
+		}
+		close, ok := parseClock(iv.Close, true)
+		if !ok {
+			return validate.NewError(f+".close", "invalid time")
+		}
+		if open == close {
+			return validate.NewError(f, "empty interval")
+		}
+		if close < open {
+			close += 24 * 60
+		}
+		spans = append(spans, span{open, close})
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return validate.NewError(field, "intervals overlap")
+		}
+	}
+	return nil
+}
+
+func validateOpeningHours(field string, hours *OpeningHours) error {
+	if hours == nil {
+		return nil
+	}
+
+	for day, intervals := range hours.Weekly {
+		err := validateIntervals(fmt.Sprintf("%s.weekly[%d]", field, day), intervals)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(hours.Specials) > 100 {
+		return validate.NewError(field+".specials", "limit to 100 days")
+	}
+	dates := make(map[string]bool)
+	for i, sp := range hours.Specials {
+		f := fmt.Sprintf("%s.specials[%d]", field, i)
+		if sp == nil {
+			return validate.NewRequiredError(f)
+		}
+		if _, err := time.Parse("2006-01-02", sp.Date); err != nil {
+			return validate.NewError(f+".date", "invalid date")
+		}
+		if dates[sp.Date] {
+			return validate.NewError(f+".date", "duplicated")
+		}
+		dates[sp.Date] = true
+		if len(sp.Note) > 200 {
+			return validate.NewError(f+".note", "too long")
+		}
+		if sp.Closed && len(sp.Intervals) > 0 {
+			return validate.NewError(f+".intervals", "must be empty when closed")
+		}
+		err := validateIntervals(f+".intervals", sp.Intervals)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTimezone(field, tz string) error {
+	if tz == "" {
+		return nil
+	}
+	if _, err := loadLocation(tz); err != nil {
+		return validate.NewError(field, "invalid")
+	}
+	return nil
+}
+
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Location returns shop's timezone location, error when timezone can not be loaded,
+// ex. invalid name or tzdata is missing
+func (shop *Shop) Location() (*time.Location, error) {
+	tz := shop.Timezone
+	if tz == "" {
+		tz = DefaultTimezone
+	}
+	return loadLocation(tz)
+}
+
+// IsOpenAt returns true if shop is open at given time
+func (shop *Shop) IsOpenAt(t time.Time) (bool, error) {
+	hours := shop.OpeningHours
+	if hours == nil {
+		return false, nil
+	}
+
+	loc, err := shop.Location()
+	if err != nil {
+		return false, err
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	// overnight intervals from yesterday
+	for _, iv := range hours.intervalsOn(today.AddDate(0, 0, -1)) {
+		if !iv.overnight() {
+			continue
+		}
+		_, close := iv.minutes()
+		if now < close {
+			return true, nil
+		}
+	}
+
+	for _, iv := range hours.intervalsOn(today) {
+		open, close := iv.minutes()
+		if iv.overnight() {
+			if now >= open {
+				return true, nil
+			}
+			continue
+		}
+		if now >= open && now < close {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func marshalOpeningHours(hours *OpeningHours) ([]byte, error) {
+	if hours == nil {
+		return nil, nil
+	}
+	return json.Marshal(hours)
+}
+
+func unmarshalOpeningHours(b []byte) (*OpeningHours, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var hours OpeningHours
+	err := json.Unmarshal(b, &hours)
+	if err != nil {
+		return nil, err
+	}
+	return &hours, nil
+}
+
+// SetOpeningHours replaces shop's timezone and opening hours when shop's version matches,
+// returns shop's new version
+func (svc *Management) SetOpeningHours(ctx context.Context, shopID, version int64, timezone string, hours *OpeningHours) (newVersion int64, err error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	err = validateTimezone("timezone", timezone)
+	if err != nil {
+		return 0, err
+	}
+	err = validateOpeningHours("openingHours", hours)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := marshalOpeningHours(hours)
+	if err != nil {
+		return 0, err
+	}
+
+	err = svc.db.QueryRowContext(ctx, `
+		update shops
+		set
+			timezone = $3,
+			opening_hours = $4,
+			updated_at = now(),
+			version = version + 1
+		where id = $1 and (version = $2 or $2 = 0)
+		returning version
+	`, shopID, version, timezone, b).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return 0, shopVersionError(ctx, svc.db, shopID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
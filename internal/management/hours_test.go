@@ -0,0 +1,118 @@
+package management
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+func TestShop_IsOpenAt(t *testing.T) {
+	bkk := time.FixedZone("ICT", 7*60*60)
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, bkk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	// 2019-03-04 is Monday
+	shop := Shop{
+		Timezone: "Asia/Bangkok",
+		OpeningHours: &OpeningHours{
+			Weekly: [7][]*Interval{
+				time.Monday:   {{"08:00", "11:00"}, {"17:00", "02:00"}},
+				time.Tuesday:  {{"00:00", "24:00"}},
+				time.Saturday: {{"10:00", "20:00"}},
+			},
+			Specials: []*SpecialDay{
+				{Date: "2019-03-09", Closed: true, Note: "holiday"},
+				{Date: "2019-03-16", Intervals: []*Interval{{"12:00", "13:00"}}},
+			},
+		},
+	}
+
+	cases := []struct {
+		Name string
+		At   time.Time
+		Open bool
+	}{
+		{"Before open", at("2019-03-04 07:59"), false},
+		{"Open", at("2019-03-04 08:00"), true},
+		{"Close is exclusive", at("2019-03-04 11:00"), false},
+		{"Overnight same day", at("2019-03-04 23:30"), true},
+		{"All day", at("2019-03-05 12:00"), true},
+		{"Overnight next day", at("2019-03-06 01:59"), false},
+		{"Not scheduled", at("2019-03-07 12:00"), false},
+		{"Special closed", at("2019-03-09 12:00"), false},
+		{"Special interval", at("2019-03-16 12:30"), true},
+		{"Special replaces weekly", at("2019-03-16 15:00"), false},
+		{"Other timezone", at("2019-03-04 08:30").In(time.UTC), true},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			open, err := shop.IsOpenAt(tC.At)
+			assert.NoError(t, err)
+			assert.Equal(t, tC.Open, open)
+		})
+	}
+
+	t.Run("Overnight from Monday", func(t *testing.T) {
+		shop := Shop{OpeningHours: &OpeningHours{
+			Weekly: [7][]*Interval{time.Monday: {{"17:00", "02:00"}}},
+		}}
+		open, _ := shop.IsOpenAt(at("2019-03-05 01:59"))
+		assert.True(t, open)
+		open, _ = shop.IsOpenAt(at("2019-03-05 02:00"))
+		assert.False(t, open)
+	})
+
+	t.Run("No opening hours", func(t *testing.T) {
+		open, err := (&Shop{}).IsOpenAt(at("2019-03-04 12:00"))
+		assert.NoError(t, err)
+		assert.False(t, open)
+	})
+
+	t.Run("Invalid timezone", func(t *testing.T) {
+		shop := Shop{Timezone: "Mars/Olympus", OpeningHours: shop.OpeningHours}
+		_, err := shop.Location()
+		assert.Error(t, err)
+
+		open, err := shop.IsOpenAt(at("2019-03-04 08:30"))
+		assert.Error(t, err)
+		assert.False(t, open)
+	})
+}
+
+func Test_validateOpeningHours(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Hours *OpeningHours
+		Field string
+	}{
+		{"Nil", nil, ""},
+		{"Valid", &OpeningHours{Weekly: [7][]*Interval{1: {{"08:00", "12:00"}, {"13:00", "01:00"}}}}, ""},
+		{"Invalid open", &OpeningHours{Weekly: [7][]*Interval{1: {{"8:00", "12:00"}}}}, "openingHours.weekly[1][0].open"},
+		{"Invalid close", &OpeningHours{Weekly: [7][]*Interval{1: {{"08:00", "25:00"}}}}, "openingHours.weekly[1][0].close"},
+		{"Empty interval", &OpeningHours{Weekly: [7][]*Interval{2: {{"08:00", "08:00"}}}}, "openingHours.weekly[2][0]"},
+		{"Overlap", &OpeningHours{Weekly: [7][]*Interval{3: {{"08:00", "12:00"}, {"11:00", "13:00"}}}}, "openingHours.weekly[3]"},
+		{"Invalid date", &OpeningHours{Specials: []*SpecialDay{{Date: "2019-13-01", Closed: true}}}, "openingHours.specials[0].date"},
+		{"Duplicated date", &OpeningHours{Specials: []*SpecialDay{{Date: "2019-01-01", Closed: true}, {Date: "2019-01-01", Closed: true}}}, "openingHours.specials[1].date"},
+		{"Closed with intervals", &OpeningHours{Specials: []*SpecialDay{{Date: "2019-01-01", Closed: true, Intervals: []*Interval{{"08:00", "09:00"}}}}}, "openingHours.specials[0].intervals"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			err := validateOpeningHours("openingHours", tC.Hours)
+			if tC.Field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &validate.Error{}, err) {
+				assert.Equal(t, tC.Field, err.(*validate.Error).Field)
+			}
+		})
+	}
+}
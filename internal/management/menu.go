@@ -0,0 +1,281 @@
+package management
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// DietaryFlag marks dietary information of a menu item
+type DietaryFlag string
+
+// Dietary flags
+const (
+	DietaryVegetarian DietaryFlag = "vegetarian"
+	DietaryVegan      DietaryFlag = "vegan"
+	DietaryHalal      DietaryFlag = "halal"
+	DietaryGlutenFree DietaryFlag = "gluten-free"
+	DietarySpicy      DietaryFlag = "spicy"
+	DietaryContainNut DietaryFlag = "contains-nuts"
+)
+
+// Valid returns true if flag is known
+func (flag DietaryFlag) Valid() bool {
+	switch flag {
+	case DietaryVegetarian, DietaryVegan, DietaryHalal, DietaryGlutenFree, DietarySpicy, DietaryContainNut:
+		return true
+	}
+	return false
+}
+
+// MenuItem entity
+type MenuItem struct {
+	ID          int64
+	Name        string
+	Description string
+	Price       int64 // satang
+	Photos      []string
+	Available   bool
+	Dietary     []DietaryFlag
+}
+
+// MenuSection entity
+type MenuSection struct {
+	ID    int64
+	Name  string
+	Items []*MenuItem
+}
+
+// Menu entity
+type Menu struct {
+	Sections []*MenuSection
+
+	// ShopVersion is shop's version when menu was retrieved
+	ShopVersion int64
+}
+
+// maxPrice is 1,000,000 baht in satang
+const maxPrice = 100000000
+
+func validateMenu(menu *Menu) error {
+	if len(menu.Sections) > 50 {
+		return validate.NewError("sections", "limit to 50 sections")
+	}
+	for i, section := range menu.Sections {
+		field := fmt.Sprintf("sections[%d]", i)
+		if section == nil {
+			return validate.NewRequiredError(field)
+		}
+
+		section.Name = strings.TrimSpace(section.Name)
+		if section.Name == "" {
+			return validate.NewRequiredError(field + ".name")
+		}
+		if utf8.RuneCountInString(section.Name) > 100 {
+			return validate.NewError(field+".name", "too long")
+		}
+		if len(section.Items) > 200 {
+			return validate.NewError(field+".items", "limit to 200 items")
+		}
+
+		for j, item := range section.Items {
+			field := fmt.Sprintf("%s.items[%d]", field, j)
+			if item == nil {
+				return validate.NewRequiredError(field)
+			}
+
+			item.Name = strings.TrimSpace(item.Name)
+			if item.Name == "" {
+				return validate.NewRequiredError(field + ".name")
+			}
+			if utf8.RuneCountInString(item.Name) > 100 {
+				return validate.NewError(field+".name", "too long")
+			}
+			if utf8.RuneCountInString(item.Description) > 1000 {
+				return validate.NewError(field+".description", "too long")
+			}
+			if item.Price < 0 {
+				return validate.NewError(field+".price", "must not be negative")
+			}
+			if item.Price > maxPrice {
+				return validate.NewError(field+".price", "too high")
+			}
+			err := validatePhotos(field+".photos", item.Photos, 5)
+			if err != nil {
+				return err
+			}
+			if item.Photos == nil {
+				// photos column is not null, pq.Array(nil) sends null
+				item.Photos = []string{}
+			}
+
+			seen := make(map[DietaryFlag]bool)
+			for k, flag := range item.Dietary {
+				if !flag.Valid() {
+					return validate.NewError(fmt.Sprintf("%s.dietary[%d]", field, k), "invalid")
+				}
+				if seen[flag] {
+					return validate.NewError(fmt.Sprintf("%s.dietary[%d]", field, k), "duplicated")
+				}
+				seen[flag] = true
+			}
+		}
+	}
+	return nil
+}
+
+// SetMenu replaces shop's menu when shop's version matches,
+// returns shop's new version
+func (svc *Management) SetMenu(ctx context.Context, shopID, version int64, menu *Menu) (newVersion int64, err error) {
+	err = validateMenu(menu)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock shop to serialize concurrent menu updates
+	newVersion, err = bumpShopVersion(ctx, tx, shopID, version)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from menu_sections
+		where shop_id = $1
+	`, shopID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, section := range menu.Sections {
+		var sectionID int64
+		err = tx.QueryRowContext(ctx, `
+			insert into menu_sections
+				(shop_id, name, position)
+			values
+				($1, $2, $3)
+			returning id
+		`, shopID, section.Name, i).Scan(&sectionID)
+		if err != nil {
+			return 0, err
+		}
+
+		for j, item := range section.Items {
+			dietary := make([]string, 0, len(item.Dietary))
+			for _, flag := range item.Dietary {
+				dietary = append(dietary, string(flag))
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				insert into menu_items
+					(section_id, shop_id, name, description, price,
+					 photos, available, dietary, position)
+				values
+					($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			`, sectionID, shopID, item.Name, item.Description, item.Price,
+				pq.Array(item.Photos), item.Available, pq.Array(dietary), j,
+			)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// GetMenu retrieves shop's menu
+func (svc *Management) GetMenu(ctx context.Context, shopID int64) (*Menu, error) {
+	// read version first, concurrent update makes version stale but never ahead of menu
+	var version int64
+	err := svc.db.QueryRowContext(ctx, `
+		select version from shops where id = $1
+	`, shopID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return nil, ErrShopNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			menu_sections.id, menu_sections.name,
+			menu_items.id, menu_items.name, menu_items.description,
+			menu_items.price, menu_items.photos, menu_items.available,
+			menu_items.dietary
+		from menu_sections
+		left join menu_items on menu_items.section_id = menu_sections.id
+		where menu_sections.shop_id = $1
+		order by menu_sections.position, menu_items.position
+	`, shopID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	menu := Menu{ShopVersion: version}
+	var section *MenuSection
+	for rows.Next() {
+		var (
+			sectionID   int64
+			sectionName string
+			itemID      *int64
+			item        MenuItem
+			name        *string
+			description *string
+			price       *int64
+			available   *bool
+			dietary     []string
+		)
+		err = rows.Scan(
+			&sectionID, &sectionName,
+			&itemID, &name, &description,
+			&price, pq.Array(&item.Photos), &available,
+			pq.Array(&dietary),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if section == nil || section.ID != sectionID {
+			section = &MenuSection{ID: sectionID, Name: sectionName}
+			menu.Sections = append(menu.Sections, section)
+		}
+		if itemID == nil {
+			// section without items
+			continue
+		}
+
+		item.ID = *itemID
+		item.Name = *name
+		item.Description = *description
+		item.Price = *price
+		item.Available = *available
+		for _, flag := range dietary {
+			item.Dietary = append(item.Dietary, DietaryFlag(flag))
+		}
+		section.Items = append(section.Items, &item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
@@ -0,0 +1,93 @@
+package management
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+func Test_validateMenu(t *testing.T) {
+	validItem := func() *MenuItem {
+		return &MenuItem{
+			Name:      "Pad Thai",
+			Price:     6000,
+			Photos:    []string{"https://example.com/padthai.jpg"},
+			Available: true,
+			Dietary:   []DietaryFlag{DietarySpicy},
+		}
+	}
+
+	cases := []struct {
+		Name  string
+		Menu  func() *Menu
+		Field string
+	}{
+		{"Valid", func() *Menu {
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{validItem()}}}}
+		}, ""},
+		{"Empty", func() *Menu { return &Menu{} }, ""},
+		{"No photos", func() *Menu {
+			item := validItem()
+			item.Photos = nil
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}}
+		}, ""},
+		{"Section name required", func() *Menu {
+			return &Menu{Sections: []*MenuSection{{Name: " "}}}
+		}, "sections[0].name"},
+		{"Item name required", func() *Menu {
+			item := validItem()
+			item.Name = ""
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{validItem(), item}}}}
+		}, "sections[0].items[1].name"},
+		{"Item name too long", func() *Menu {
+			item := validItem()
+			item.Name = strings.Repeat("ก", 101)
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}}
+		}, "sections[0].items[0].name"},
+		{"Negative price", func() *Menu {
+			item := validItem()
+			item.Price = -1
+			return &Menu{Sections: []*MenuSection{{Name: "A"}, {Name: "B", Items: []*MenuItem{item}}}}
+		}, "sections[1].items[0].price"},
+		{"Invalid photo", func() *Menu {
+			item := validItem()
+			item.Photos = []string{"not url"}
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}}
+		}, "sections[0].items[0].photos[0]"},
+		{"Invalid dietary", func() *Menu {
+			item := validItem()
+			item.Dietary = []DietaryFlag{"keto"}
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}}
+		}, "sections[0].items[0].dietary[0]"},
+		{"Duplicated dietary", func() *Menu {
+			item := validItem()
+			item.Dietary = []DietaryFlag{DietaryVegan, DietaryVegan}
+			return &Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}}
+		}, "sections[0].items[0].dietary[1]"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			err := validateMenu(tC.Menu())
+			if tC.Field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &validate.Error{}, err) {
+				assert.Equal(t, tC.Field, err.(*validate.Error).Field)
+			}
+		})
+	}
+}
+
+func Test_validateMenu_NoPhotos(t *testing.T) {
+	item := &MenuItem{Name: "Pad Thai", Price: 6000}
+	err := validateMenu(&Menu{Sections: []*MenuSection{{Name: "Mains", Items: []*MenuItem{item}}}})
+	assert.NoError(t, err)
+
+	// nil is stored as null which violates not null
+	assert.NotNil(t, item.Photos)
+	assert.Empty(t, item.Photos)
+}
@@ -0,0 +1,47 @@
+package management
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// ReplyReview sets shop's reply to a review, empty reply removes the reply
+func (svc *Management) ReplyReview(ctx context.Context, shopID, reviewID int64, reply string) error {
+	reply = strings.TrimSpace(reply)
+	if utf8.RuneCountInString(reply) > 2000 {
+		return validate.NewError("reply", "too long")
+	}
+
+	res, err := svc.db.ExecContext(ctx, `
+		update reviews
+		set
+			reply = $3,
+			replied_at = case when $3 = '' then null else now() end
+		where id = $1 and shop_id = $2
+	`, reviewID, shopID, reply)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReviewNotFound
+	}
+	return nil
+}
+
+// DeleteReview removes review, used by moderators
+func (svc *Management) DeleteReview(ctx context.Context, reviewID int64) error {
+	res, err := svc.db.ExecContext(ctx, `
+		delete from reviews
+		where id = $1
+	`, reviewID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReviewNotFound
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package management
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SearchShops type
+type SearchShops struct {
+	// Categories, Cuisines and Tags filter shops by term slugs,
+	// slugs of the same kind are OR-ed, different kinds are AND-ed
+	Categories []string
+	Cuisines   []string
+	Tags       []string
+
+	// OpenAt filters only shops open at given time, zero value disables filter
+	OpenAt time.Time
+}
+
+// Facet is the number of matched shops linked to a term
+type Facet struct {
+	Slug  string
+	Name  string
+	Count int
+}
+
+// Facets type
+type Facets struct {
+	Categories []*Facet
+	Cuisines   []*Facet
+	Tags       []*Facet
+}
+
+// SearchResult type
+type SearchResult struct {
+	Shops  []*Shop
+	Facets *Facets
+}
+
+// SearchShops retrieves shops matched the filter with facet counts.
+// Facets of a kind are counted without the filter of that kind,
+// so selecting a term still shows counts of the other terms of the same kind
+func (svc *Management) SearchShops(ctx context.Context, filter *SearchShops) (*SearchResult, error) {
+	where, args := filter.conditions("")
+	rows, err := svc.db.QueryContext(ctx, `
+		select `+shopColumns+`
+		from shops
+		where true
+	`+where+`
+		order by id desc
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shops []*Shop
+	for rows.Next() {
+		shop, err := scanShop(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		shops = append(shops, shop)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.loadShopTerms(ctx, shops)
+	if err != nil {
+		return nil, err
+	}
+
+	var facets Facets
+	for _, x := range []struct {
+		Kind   TermKind
+		Facets *[]*Facet
+	}{
+		{TermCategory, &facets.Categories},
+		{TermCuisine, &facets.Cuisines},
+		{TermTag, &facets.Tags},
+	} {
+		*x.Facets, err = svc.countFacets(ctx, filter, x.Kind)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &SearchResult{
+		Shops:  shops,
+		Facets: &facets,
+	}, nil
+}
+
+// conditions returns sql conditions of shops matched the filter
+// except the term filter of the given kind, conditions start with "and"
+func (filter *SearchShops) conditions(except TermKind) (where string, args []interface{}) {
+	var conds []string
+	for _, f := range []struct {
+		Kind  TermKind
+		Slugs []string
+	}{
+		{TermCategory, filter.Categories},
+		{TermCuisine, filter.Cuisines},
+		{TermTag, filter.Tags},
+	} {
+		if len(f.Slugs) == 0 || f.Kind == except {
+			continue
+		}
+
+		slugs := make([]string, 0, len(f.Slugs))
+		for _, slug := range f.Slugs {
+			slugs = append(slugs, normalizeSlug(slug))
+		}
+
+		args = append(args, f.Kind, pq.Array(slugs))
+		conds = append(conds, fmt.Sprintf(`
+			and exists (
+				select 1
+				from shop_terms
+				left join taxonomy_terms on shop_terms.term_id = taxonomy_terms.id
+				where shop_terms.shop_id = shops.id
+					and taxonomy_terms.kind = $%d
+					and taxonomy_terms.slug = any($%d)
+			)
+		`, len(args)-1, len(args)))
+	}
+
+	if !filter.OpenAt.IsZero() {
+		args = append(args, filter.OpenAt)
+		conds = append(conds, fmt.Sprintf(`
+			and shop_open_at(shops.opening_hours, shops.timezone, $%d)
+		`, len(args)))
+	}
+
+	return strings.Join(conds, ""), args
+}
+
+// countFacets counts shops matched the filter by terms of the kind,
+// without the filter of the kind. Most used terms come first
+func (svc *Management) countFacets(ctx context.Context, filter *SearchShops, kind TermKind) ([]*Facet, error) {
+	where, args := filter.conditions(kind)
+	args = append(args, kind)
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			taxonomy_terms.slug, taxonomy_terms.name, count(*)
+		from shop_terms
+		left join taxonomy_terms on shop_terms.term_id = taxonomy_terms.id
+		left join shops on shop_terms.shop_id = shops.id
+		where taxonomy_terms.kind = $`+strconv.Itoa(len(args))+`
+	`+where+`
+		group by taxonomy_terms.id
+		order by count(*) desc, taxonomy_terms.slug
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facets []*Facet
+	for rows.Next() {
+		var f Facet
+		err = rows.Scan(&f.Slug, &f.Name, &f.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets = append(facets, &f)
+	}
+	return facets, rows.Err()
+}
@@ -0,0 +1,46 @@
+package management
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchShops_conditions(t *testing.T) {
+	openAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	filter := SearchShops{
+		Categories: []string{"Cafe"},
+		Cuisines:   []string{"thai", "Japanese"},
+		OpenAt:     openAt,
+	}
+
+	t.Run("All", func(t *testing.T) {
+		where, args := filter.conditions("")
+		assert.Equal(t, []interface{}{
+			TermCategory, pq.Array([]string{"cafe"}),
+			TermCuisine, pq.Array([]string{"thai", "japanese"}),
+			openAt,
+		}, args)
+		assert.Equal(t, 2, strings.Count(where, "and exists"))
+		assert.Contains(t, where, "shop_open_at(shops.opening_hours, shops.timezone, $5)")
+	})
+
+	t.Run("Except own kind", func(t *testing.T) {
+		where, args := filter.conditions(TermCuisine)
+		assert.Equal(t, []interface{}{
+			TermCategory, pq.Array([]string{"cafe"}),
+			openAt,
+		}, args)
+		assert.Equal(t, 1, strings.Count(where, "and exists"))
+		assert.Contains(t, where, "$3")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		where, args := (&SearchShops{}).conditions("")
+		assert.Empty(t, where)
+		assert.Empty(t, args)
+	})
+}
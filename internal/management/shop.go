@@ -28,56 +28,197 @@ type CreateShop struct {
 	Name        string
 	Description string
 	Photos      []string
+
+	// Timezone is IANA timezone name, default to DefaultTimezone
+	Timezone     string
+	OpeningHours *OpeningHours
 }
 
 // CreateShop creates new shop
 func (svc *Management) CreateShop(ctx context.Context, shop *CreateShop) (shopID int64, err error) {
-	if shop.Name == "" {
-		return 0, validate.NewRequiredError("name")
+	err = validateShopDetails(shop.Name, shop.Description, shop.Photos)
+	if err != nil {
+		return 0, err
+	}
+	if shop.Timezone == "" {
+		shop.Timezone = DefaultTimezone
+	}
+	err = validateTimezone("timezone", shop.Timezone)
+	if err != nil {
+		return 0, err
+	}
+	err = validateOpeningHours("openingHours", shop.OpeningHours)
+	if err != nil {
+		return 0, err
+	}
+
+	openingHours, err := marshalOpeningHours(shop.OpeningHours)
+	if err != nil {
+		return 0, err
 	}
-	if utf8.RuneCountInString(shop.Name) > 100 {
-		return 0, validate.NewError("name", "too long")
+
+	err = svc.db.QueryRowContext(ctx, `
+		insert into shops
+			(name, description, photos, timezone, opening_hours)
+		values
+			($1, $2, $3, $4, $5)
+		returning id
+	`, shop.Name, shop.Description, pq.Array(shop.Photos), shop.Timezone, openingHours).Scan(&shopID)
+	if err != nil {
+		return 0, err
 	}
-	if shop.Description == "" {
-		return 0, validate.NewRequiredError("description")
+	return shopID, nil
+}
+
+// UpdateShop type
+type UpdateShop struct {
+	Name        string
+	Description string
+	Photos      []string
+}
+
+// AnyVersion skips shop's version check, for clients which do not send version
+const AnyVersion int64 = 0
+
+// UpdateShop updates shop's details when shop's version matches,
+// returns shop's new version
+func (svc *Management) UpdateShop(ctx context.Context, shopID, version int64, shop *UpdateShop) (newVersion int64, err error) {
+	err = validateShopDetails(shop.Name, shop.Description, shop.Photos)
+	if err != nil {
+		return 0, err
 	}
-	if utf8.RuneCountInString(shop.Description) > 2000 {
-		return 0, validate.NewError("description", "too long")
+
+	err = svc.db.QueryRowContext(ctx, `
+		update shops
+		set
+			name = $3,
+			description = $4,
+			photos = $5,
+			updated_at = now(),
+			version = version + 1
+		where id = $1 and (version = $2 or $2 = 0)
+		returning version
+	`, shopID, version, shop.Name, shop.Description, pq.Array(shop.Photos)).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return 0, shopVersionError(ctx, svc.db, shopID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// DeleteShop deletes shop with its reviews when shop's version matches
+func (svc *Management) DeleteShop(ctx context.Context, shopID, version int64) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = bumpShopVersion(ctx, tx, shopID, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from reviews
+		where shop_id = $1
+	`, shopID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from shops
+		where id = $1
+	`, shopID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// bumpShopVersion locks shop and increments its version when version matches,
+// changes to shop's parts must bump shop's version in the same transaction
+func bumpShopVersion(ctx context.Context, q queryRower, shopID, version int64) (newVersion int64, err error) {
+	err = q.QueryRowContext(ctx, `
+		update shops
+		set
+			updated_at = now(),
+			version = version + 1
+		where id = $1 and (version = $2 or $2 = 0)
+		returning version
+	`, shopID, version).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return 0, shopVersionError(ctx, q, shopID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// shopVersionError returns why shop's version did not match
+func shopVersionError(ctx context.Context, q queryRower, shopID int64) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, `
+		select exists (select 1 from shops where id = $1)
+	`, shopID).Scan(&exists)
+	if err != nil {
+		return err
 	}
-	if len(shop.Photos) > 10 {
-		return 0, validate.NewError("photos", "limit to 10 photos")
+	if !exists {
+		return ErrShopNotFound
 	}
-	for i, photo := range shop.Photos {
+	return ErrShopVersionMismatch
+}
+
+func validateShopDetails(name, description string, photos []string) error {
+	if name == "" {
+		return validate.NewRequiredError("name")
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		return validate.NewError("name", "too long")
+	}
+	if description == "" {
+		return validate.NewRequiredError("description")
+	}
+	if utf8.RuneCountInString(description) > 2000 {
+		return validate.NewError("description", "too long")
+	}
+	return validatePhotos("photos", photos, 10)
+}
+
+func validatePhotos(field string, photos []string, limit int) error {
+	if len(photos) > limit {
+		return validate.NewError(field, fmt.Sprintf("limit to %d photos", limit))
+	}
+	for i, photo := range photos {
 		if l := len(photo); l == 0 {
-			return 0, validate.NewError(
-				fmt.Sprintf("photos[%d]", i),
+			return validate.NewError(
+				fmt.Sprintf("%s[%d]", field, i),
 				"photo url empty",
 			)
 		} else if l > 200 {
-			return 0, validate.NewError(
-				fmt.Sprintf("photos[%d]", i),
+			return validate.NewError(
+				fmt.Sprintf("%s[%d]", field, i),
 				"photo url too long",
 			)
 		}
 		if !govalidator.IsURL(photo) {
-			return 0, validate.NewError(
-				fmt.Sprintf("photos[%d]", i),
+			return validate.NewError(
+				fmt.Sprintf("%s[%d]", field, i),
 				"photo is not an url",
 			)
 		}
 	}
-
-	err = svc.db.QueryRowContext(ctx, `
-		insert into shops
-			(name, description, photos)
-		values
-			($1, $2, $3)
-		returning id
-	`, shop.Name, shop.Description, pq.Array(shop.Photos)).Scan(&shopID)
-	if err != nil {
-		return 0, err
-	}
-	return shopID, nil
+	return nil
 }
 
 // Shop entity
@@ -87,38 +228,132 @@ type Shop struct {
 	Description string
 	Photos      []string
 	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Version     int64
+	Terms       []*Term
+
+	Timezone     string
+	OpeningHours *OpeningHours
+}
+
+const shopColumns = `
+	shops.id, shops.name, shops.description, shops.photos, shops.created_at,
+	shops.updated_at, shops.version, shops.timezone, shops.opening_hours
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanShop(scan scanner) (*Shop, error) {
+	var (
+		shop         Shop
+		openingHours []byte
+	)
+	err := scan.Scan(
+		&shop.ID, &shop.Name, &shop.Description,
+		pq.Array(&shop.Photos), &shop.CreatedAt,
+		&shop.UpdatedAt, &shop.Version, &shop.Timezone, &openingHours,
+	)
+	if err != nil {
+		return nil, err
+	}
+	shop.OpeningHours, err = unmarshalOpeningHours(openingHours)
+	if err != nil {
+		return nil, err
+	}
+	return &shop, nil
+}
+
+// TermsOf returns shop's terms of given kind
+func (shop *Shop) TermsOf(kind TermKind) []*Term {
+	var terms []*Term
+	for _, term := range shop.Terms {
+		if term.Kind == kind {
+			terms = append(terms, term)
+		}
+	}
+	return terms
 }
 
 // ListShops retrieves all shops
 func (svc *Management) ListShops(ctx context.Context) ([]*Shop, error) {
+	var shops []*Shop
+	err := svc.EachShop(ctx, func(shop *Shop) error {
+		shops = append(shops, shop)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shops, nil
+}
+
+// EachShop calls fn for every shop while reading rows,
+// so all shops do not have to be loaded into memory.
+// Iteration stops at the first error from fn
+func (svc *Management) EachShop(ctx context.Context, fn func(*Shop) error) error {
 	rows, err := svc.db.QueryContext(ctx, `
-		select
-			id, name, description, photos, created_at
+		select `+shopColumns+`
 		from shops
 		order by id desc
 	`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var shops []*Shop
 	for rows.Next() {
-		var shop Shop
-		err = rows.Scan(
-			&shop.ID, &shop.Name, &shop.Description,
-			pq.Array(&shop.Photos), &shop.CreatedAt,
-		)
+		shop, err := scanShop(rows)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		shops = append(shops, &shop)
+		err = fn(shop)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = rows.Err()
+	return rows.Err()
+}
+
+// ShopsStat is metadata of all shops, changes whenever any shop changes
+type ShopsStat struct {
+	Count        int
+	LastModified time.Time
+}
+
+// StatShops retrieves count and latest update time of all shops
+func (svc *Management) StatShops(ctx context.Context) (*ShopsStat, error) {
+	var stat ShopsStat
+	err := svc.db.QueryRowContext(ctx, `
+		select count(*), coalesce(max(updated_at), 'epoch')
+		from shops
+	`).Scan(&stat.Count, &stat.LastModified)
 	if err != nil {
 		return nil, err
 	}
-	return shops, nil
+	return &stat, nil
+}
+
+// GetShop retrieves shop by id
+func (svc *Management) GetShop(ctx context.Context, shopID int64) (*Shop, error) {
+	shop, err := scanShop(svc.db.QueryRowContext(ctx, `
+		select `+shopColumns+`
+		from shops
+		where id = $1
+	`, shopID))
+	if err == sql.ErrNoRows {
+		return nil, ErrShopNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.loadShopTerms(ctx, []*Shop{shop})
+	if err != nil {
+		return nil, err
+	}
+	return shop, nil
 }
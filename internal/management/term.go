@@ -0,0 +1,353 @@
+package management
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// TermKind is the kind of taxonomy term
+type TermKind string
+
+// Term kinds
+const (
+	TermCategory TermKind = "category"
+	TermCuisine  TermKind = "cuisine"
+	TermTag      TermKind = "tag"
+)
+
+// Valid returns true if kind is known
+func (kind TermKind) Valid() bool {
+	switch kind {
+	case TermCategory, TermCuisine, TermTag:
+		return true
+	}
+	return false
+}
+
+// Term entity
+type Term struct {
+	ID        int64
+	Kind      TermKind
+	Slug      string
+	Name      string
+	CreatedAt time.Time
+}
+
+var reSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func normalizeSlug(slug string) string {
+	slug = strings.ToLower(slug)
+	slug = strings.TrimSpace(slug)
+	return slug
+}
+
+func validateSlug(field, slug string) error {
+	if slug == "" {
+		return validate.NewRequiredError(field)
+	}
+	if len(slug) > 50 {
+		return validate.NewError(field, "too long")
+	}
+	if !reSlug.MatchString(slug) {
+		return validate.NewError(field, "invalid")
+	}
+	return nil
+}
+
+func validateTermName(name string) error {
+	if name == "" {
+		return validate.NewRequiredError("name")
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		return validate.NewError("name", "too long")
+	}
+	return nil
+}
+
+func isTermSlugConflict(err error) bool {
+	if err, ok := err.(*pq.Error); ok {
+		return err.Code == "23505" && err.Constraint == "taxonomy_terms_kind_slug_idx"
+	}
+	return false
+}
+
+// CreateTerm type
+type CreateTerm struct {
+	Kind TermKind
+	Slug string
+	Name string
+}
+
+// CreateTerm creates new taxonomy term
+func (svc *Management) CreateTerm(ctx context.Context, term *CreateTerm) (termID int64, err error) {
+	term.Slug = normalizeSlug(term.Slug)
+	term.Name = strings.TrimSpace(term.Name)
+
+	if !term.Kind.Valid() {
+		return 0, validate.NewError("kind", "invalid")
+	}
+	err = validateSlug("slug", term.Slug)
+	if err != nil {
+		return 0, err
+	}
+	err = validateTermName(term.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = svc.db.QueryRowContext(ctx, `
+		insert into taxonomy_terms
+			(kind, slug, name)
+		values
+			($1, $2, $3)
+		returning id
+	`, term.Kind, term.Slug, term.Name).Scan(&termID)
+	if isTermSlugConflict(err) {
+		return 0, validate.NewError("slug", "not available")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return termID, nil
+}
+
+// ListTerms retrieves all terms of given kind
+func (svc *Management) ListTerms(ctx context.Context, kind TermKind) ([]*Term, error) {
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			id, kind, slug, name, created_at
+		from taxonomy_terms
+		where kind = $1
+		order by name
+	`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []*Term
+	for rows.Next() {
+		var term Term
+		err = rows.Scan(
+			&term.ID, &term.Kind, &term.Slug, &term.Name, &term.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		terms = append(terms, &term)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// UpdateTerm type
+type UpdateTerm struct {
+	Slug string
+	Name string
+}
+
+// UpdateTerm updates term of given kind
+func (svc *Management) UpdateTerm(ctx context.Context, kind TermKind, termID int64, term *UpdateTerm) error {
+	term.Slug = normalizeSlug(term.Slug)
+	term.Name = strings.TrimSpace(term.Name)
+
+	err := validateSlug("slug", term.Slug)
+	if err != nil {
+		return err
+	}
+	err = validateTermName(term.Name)
+	if err != nil {
+		return err
+	}
+
+	res, err := svc.db.ExecContext(ctx, `
+		update taxonomy_terms
+		set
+			slug = $3,
+			name = $4
+		where id = $1 and kind = $2
+	`, termID, kind, term.Slug, term.Name)
+	if isTermSlugConflict(err) {
+		return validate.NewError("slug", "not available")
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTermNotFound
+	}
+	return nil
+}
+
+// DeleteTerm deletes term of given kind and unlinks it from all shops
+func (svc *Management) DeleteTerm(ctx context.Context, kind TermKind, termID int64) error {
+	res, err := svc.db.ExecContext(ctx, `
+		delete from taxonomy_terms
+		where id = $1 and kind = $2
+	`, termID, kind)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTermNotFound
+	}
+	return nil
+}
+
+// ShopTerms type
+type ShopTerms struct {
+	Categories []string
+	Cuisines   []string
+	Tags       []string
+}
+
+// SetShopTerms replaces all terms linked to the shop when shop's version matches,
+// returns shop's new version.
+// Categories and cuisines must already exist, unknown tags are created.
+func (svc *Management) SetShopTerms(ctx context.Context, shopID, version int64, terms *ShopTerms) (newVersion int64, err error) {
+	groups := []struct {
+		Field string
+		Kind  TermKind
+		Slugs []string
+	}{
+		{"categories", TermCategory, terms.Categories},
+		{"cuisines", TermCuisine, terms.Cuisines},
+		{"tags", TermTag, terms.Tags},
+	}
+	for _, g := range groups {
+		if len(g.Slugs) > 20 {
+			return 0, validate.NewError(g.Field, "limit to 20 items")
+		}
+		for i := range g.Slugs {
+			g.Slugs[i] = normalizeSlug(g.Slugs[i])
+			err := validateSlug(fmt.Sprintf("%s[%d]", g.Field, i), g.Slugs[i])
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newVersion, err = bumpShopVersion(ctx, tx, shopID, version)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		delete from shop_terms
+		where shop_id = $1
+	`, shopID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range groups {
+		for i, slug := range g.Slugs {
+			var termID int64
+			err = tx.QueryRowContext(ctx, `
+				select id
+				from taxonomy_terms
+				where kind = $1 and slug = $2
+			`, g.Kind, slug).Scan(&termID)
+			if err == sql.ErrNoRows && g.Kind == TermTag {
+				err = tx.QueryRowContext(ctx, `
+					insert into taxonomy_terms
+						(kind, slug, name)
+					values
+						($1, $2, $2)
+					returning id
+				`, g.Kind, slug).Scan(&termID)
+			}
+			if err == sql.ErrNoRows {
+				return 0, validate.NewError(fmt.Sprintf("%s[%d]", g.Field, i), "not found")
+			}
+			if err != nil {
+				return 0, err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				insert into shop_terms
+					(shop_id, term_id)
+				values
+					($1, $2)
+				on conflict do nothing
+			`, shopID, termID)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// loadShopTerms fills terms for all given shops
+func (svc *Management) loadShopTerms(ctx context.Context, shops []*Shop) error {
+	if len(shops) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(shops))
+	byID := make(map[int64]*Shop, len(shops))
+	for _, shop := range shops {
+		ids = append(ids, shop.ID)
+		byID[shop.ID] = shop
+	}
+
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			shop_terms.shop_id,
+			taxonomy_terms.id, taxonomy_terms.kind, taxonomy_terms.slug,
+			taxonomy_terms.name, taxonomy_terms.created_at
+		from shop_terms
+		left join taxonomy_terms on shop_terms.term_id = taxonomy_terms.id
+		where shop_terms.shop_id = any($1)
+		order by taxonomy_terms.kind, taxonomy_terms.name
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			shopID int64
+			term   Term
+		)
+		err = rows.Scan(
+			&shopID,
+			&term.ID, &term.Kind, &term.Slug, &term.Name, &term.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		if shop := byID[shopID]; shop != nil {
+			shop.Terms = append(shop.Terms, &term)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often memory store drops full buckets
+const sweepInterval = time.Minute
+
+// MemoryStore stores buckets in memory, for single replica deployments
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	rate Rate
+}
+
+// NewMemoryStore creates new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (*Result, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b := s.buckets[key]
+	if b == nil {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	b.rate = rate
+	return b.take(rate, now), nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.full(b.rate, now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
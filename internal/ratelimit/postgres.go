@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// PostgresStore stores buckets in postgres, for multi-replica deployments
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates new postgres store
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// Take implements Store
+func (s *PostgresStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (*Result, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+
+	// timestamp column drops time zone, store as utc
+	now = now.UTC()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		insert into rate_limits
+			(key, tokens, updated_at)
+		values
+			($1, $2, $3)
+		on conflict (key) do nothing
+	`, key, rate.Limit, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bucket
+	err = tx.QueryRowContext(ctx, `
+		select tokens, updated_at
+		from rate_limits
+		where key = $1
+		for update
+	`, key).Scan(&b.Tokens, &b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r := b.take(rate, now)
+
+	_, err = tx.ExecContext(ctx, `
+		update rate_limits
+		set tokens = $2, updated_at = $3
+		where key = $1
+	`, key, b.Tokens, b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Purge deletes buckets not used since before
+func (s *PostgresStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		delete from rate_limits
+		where updated_at < $1
+	`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunPurgeJob purges buckets unused for maxAge every interval until ctx is canceled,
+// maxAge should be longer than the longest rate's period
+func (s *PostgresStore) RunPurgeJob(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := s.Purge(ctx, time.Now().Add(-maxAge))
+		if err != nil {
+			log.Println("ratelimit: purge error;", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrInvalidRate is returned when rate's limit or period is not positive
+var ErrInvalidRate = errors.New("ratelimit: limit and period must be positive")
+
+// Rate is token bucket's rate, bucket holds up to Limit tokens
+// and refills Limit tokens every Per
+type Rate struct {
+	Limit int
+	Per   time.Duration
+}
+
+// Validate returns ErrInvalidRate when bucket can not be refilled from the rate
+func (rate Rate) Validate() error {
+	if rate.Limit <= 0 || rate.Per <= 0 {
+		return ErrInvalidRate
+	}
+	return nil
+}
+
+// Result is the result of taking a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is duration until bucket is full again
+	Reset time.Duration
+
+	// RetryAfter is duration until next token available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Store takes token from key's bucket
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate, now time.Time) (*Result, error)
+}
+
+// bucket is token bucket's state
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// take refills bucket up to now then takes a token if available
+func (b *bucket) take(rate Rate, now time.Time) *Result {
+	limit := float64(rate.Limit)
+	perToken := float64(rate.Per) / limit
+
+	if b.UpdatedAt.IsZero() {
+		b.Tokens = limit
+	} else if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens = math.Min(limit, b.Tokens+float64(elapsed)/perToken)
+	}
+	b.UpdatedAt = now
+
+	r := Result{Limit: rate.Limit}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = time.Duration((1 - b.Tokens) * perToken)
+	}
+	r.Remaining = int(b.Tokens)
+	r.Reset = time.Duration((limit - b.Tokens) * perToken)
+	return &r
+}
+
+// full returns true if bucket would be full at now, full bucket is the same as no bucket
+func (b *bucket) full(rate Rate, now time.Time) bool {
+	perToken := float64(rate.Per) / float64(rate.Limit)
+	return b.Tokens+float64(now.Sub(b.UpdatedAt))/perToken >= float64(rate.Limit)
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	rate := Rate{Limit: 3, Per: 3 * time.Second}
+	now := time.Now()
+	s := NewMemoryStore()
+
+	for i := 2; i >= 0; i-- {
+		r, err := s.Take(ctx, "a", rate, now)
+		assert.NoError(t, err)
+		assert.True(t, r.Allowed)
+		assert.Equal(t, 3, r.Limit)
+		assert.Equal(t, i, r.Remaining)
+	}
+
+	r, _ := s.Take(ctx, "a", rate, now)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.Equal(t, time.Second, r.RetryAfter)
+	assert.Equal(t, 3*time.Second, r.Reset)
+
+	// other key has its own bucket
+	r, _ = s.Take(ctx, "b", rate, now)
+	assert.True(t, r.Allowed)
+
+	// refilled one token
+	r, _ = s.Take(ctx, "a", rate, now.Add(time.Second))
+	assert.True(t, r.Allowed)
+	r, _ = s.Take(ctx, "a", rate, now.Add(time.Second))
+	assert.False(t, r.Allowed)
+
+	// refill never exceeds limit
+	r, _ = s.Take(ctx, "a", rate, now.Add(time.Hour))
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 2, r.Remaining)
+}
+
+func TestMemoryStore_sweep(t *testing.T) {
+	ctx := context.Background()
+	rate := Rate{Limit: 1, Per: time.Second}
+	now := time.Now()
+	s := NewMemoryStore()
+
+	s.Take(ctx, "a", rate, now)
+	s.Take(ctx, "b", Rate{Limit: 1, Per: time.Hour}, now)
+	s.Take(ctx, "c", rate, now.Add(sweepInterval))
+	assert.Len(t, s.buckets, 2, "expected full bucket dropped")
+	assert.Nil(t, s.buckets["a"])
+}
+
+func TestRate_Validate(t *testing.T) {
+	cases := []struct {
+		Name string
+		rate Rate
+		err  error
+	}{
+		{"Valid", Rate{Limit: 1, Per: time.Second}, nil},
+		{"Zero limit", Rate{Per: time.Second}, ErrInvalidRate},
+		{"Negative limit", Rate{Limit: -1, Per: time.Second}, ErrInvalidRate},
+		{"Zero period", Rate{Limit: 1}, ErrInvalidRate},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			assert.Equal(t, tC.err, tC.rate.Validate())
+		})
+	}
+
+	_, err := NewMemoryStore().Take(context.Background(), "k", Rate{}, time.Now())
+	assert.Equal(t, ErrInvalidRate, err)
+}
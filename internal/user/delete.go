@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/acoshift/wongnok/internal/auth"
+)
+
+// Delete schedules user for deletion.
+// User's reviews are anonymized and all sessions are revoked immediately,
+// personal data will be removed by PurgeDeleted after grace period.
+func (svc *User) Delete(ctx context.Context, userID int64) error {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		update users
+		set deleted_at = $2
+		where id = $1 and deleted_at is null
+	`, userID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	// signed access tokens stay valid until revoked
+	err = auth.RevokeUserSessions(ctx, tx, userID, svc.accessTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range []string{
+		`update reviews set user_id = null where user_id = $1`,
+		`delete from api_keys where user_id = $1`,
+		`delete from user_roles where user_id = $1`,
+		`delete from shop_owners where user_id = $1`,
+
+		// let the person sign up again with the same social account
+		`delete from user_identities where user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, q, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// drop cached sessions on every replica
+	err = auth.NotifyUserChanged(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeDeleted hard-deletes users which were deleted before given time
+func (svc *User) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	// deleted_at is stored without time zone in UTC, see Delete
+	res, err := svc.db.ExecContext(ctx, `
+		delete from users
+		where deleted_at < $1
+	`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunPurgeJob purges deleted users older than grace period every interval
+// until ctx is canceled
+func (svc *User) RunPurgeJob(ctx context.Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := svc.PurgeDeleted(ctx, time.Now().Add(-grace))
+		if err != nil {
+			log.Println("user: purge deleted users error;", err)
+		} else if n > 0 {
+			log.Printf("user: purged %d deleted users\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
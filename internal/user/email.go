@@ -0,0 +1,189 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/mailer"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// emailTokenTTL is the duration verification link is valid
+const emailTokenTTL = 24 * time.Hour
+
+// NormalizeEmail normalizes email for storing and comparing
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	email = strings.ToLower(email)
+	return email
+}
+
+// emailTokenPayload is the signed part of email token,
+// encoded as json so email may contain any character
+type emailTokenPayload struct {
+	UserID  int64  `json:"uid"`
+	Email   string `json:"email"`
+	Expires int64  `json:"exp"`
+}
+
+// signEmailToken creates token proves user owns email until expires
+func signEmailToken(secret []byte, userID int64, email string, expires time.Time) string {
+	payload, _ := json.Marshal(emailTokenPayload{userID, email, expires.Unix()})
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseEmailToken verifies token's signature and expiry
+func parseEmailToken(secret []byte, token string, now time.Time) (userID int64, email string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return 0, "", ErrInvalidToken
+	}
+
+	var p emailTokenPayload
+	err = json.Unmarshal(payload, &p)
+	if err != nil || p.UserID <= 0 || p.Email == "" {
+		return 0, "", ErrInvalidToken
+	}
+	if now.Unix() > p.Expires {
+		return 0, "", ErrTokenExpired
+	}
+	return p.UserID, p.Email, nil
+}
+
+// SetEmail changes user's email and sends verification email,
+// empty email removes user's email
+func (svc *User) SetEmail(ctx context.Context, userID int64, email string) error {
+	email = NormalizeEmail(email)
+	if len(email) > 254 {
+		return validate.NewError("email", "too long")
+	}
+	if email != "" && !govalidator.IsEmail(email) {
+		return validate.NewError("email", "invalid")
+	}
+
+	var nullEmail *string
+	if email != "" {
+		nullEmail = &email
+	}
+
+	// changing email always requires verification again,
+	// email is unique only when verified so others can not hold an email they do not own
+	res, err := svc.db.ExecContext(ctx, `
+		update users
+		set
+			email = $2,
+			email_verified_at = null
+		where id = $1 and deleted_at is null
+			and email is distinct from $2
+	`, userID, nullEmail)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// email not changed
+		return nil
+	}
+
+	if email == "" {
+		return nil
+	}
+	return svc.sendVerificationEmail(ctx, userID, email)
+}
+
+// ResendVerificationEmail sends verification email to user's unverified email
+func (svc *User) ResendVerificationEmail(ctx context.Context, userID int64) error {
+	var (
+		email    *string
+		verified bool
+	)
+	err := svc.db.QueryRowContext(ctx, `
+		select email, email_verified_at is not null
+		from users
+		where id = $1 and deleted_at is null
+	`, userID).Scan(&email, &verified)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if email == nil {
+		return validate.NewRequiredError("email")
+	}
+	if verified {
+		return ErrEmailVerified
+	}
+
+	return svc.sendVerificationEmail(ctx, userID, *email)
+}
+
+func (svc *User) sendVerificationEmail(ctx context.Context, userID int64, email string) error {
+	token := signEmailToken(svc.emailSecret, userID, email, time.Now().Add(emailTokenTTL))
+
+	link := svc.verifyEmailURL
+	if strings.Contains(link, "?") {
+		link += "&token=" + url.QueryEscape(token)
+	} else {
+		link += "?token=" + url.QueryEscape(token)
+	}
+
+	return svc.mailer.Send(ctx, &mailer.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: "Please verify your email for Wongnok by opening the link below.\n\n" +
+			link + "\n\n" +
+			"The link will expire in 24 hours.\n",
+	})
+}
+
+// VerifyEmail marks user's email as verified
+func (svc *User) VerifyEmail(ctx context.Context, token string) error {
+	userID, email, err := parseEmailToken(svc.emailSecret, token, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// token for old email is invalid after email changed
+	res, err := svc.db.ExecContext(ctx, `
+		update users
+		set email_verified_at = coalesce(email_verified_at, now())
+		where id = $1 and email = $2 and deleted_at is null
+	`, userID, email)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" && err.Constraint == "users_email_idx" {
+		return ErrEmailNotAvailable
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
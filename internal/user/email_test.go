@@ -0,0 +1,56 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	token := signEmailToken(secret, 10, "tester@example.com", now.Add(time.Hour))
+
+	t.Run("Valid", func(t *testing.T) {
+		userID, email, err := parseEmailToken(secret, token, now)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 10, userID)
+		assert.Equal(t, "tester@example.com", email)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, _, err := parseEmailToken(secret, token, now.Add(2*time.Hour))
+		assert.Equal(t, ErrTokenExpired, err)
+	})
+
+	t.Run("Invalid secret", func(t *testing.T) {
+		_, _, err := parseEmailToken([]byte("other"), token, now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		other := signEmailToken([]byte("other"), 11, "tester@example.com", now.Add(time.Hour))
+		_, _, err := parseEmailToken(secret, other[:len(other)-43]+token[len(token)-43:], now)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("Separator in email", func(t *testing.T) {
+		token := signEmailToken(secret, 10, `"a|b.c"@example.com`, now.Add(time.Hour))
+		userID, email, err := parseEmailToken(secret, token, now)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 10, userID)
+		assert.Equal(t, `"a|b.c"@example.com`, email)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, x := range []string{"", "abc", "a.b.c", "!!.!!"} {
+			_, _, err := parseEmailToken(secret, x, now)
+			assert.Equal(t, ErrInvalidToken, err, x)
+		}
+	})
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	assert.Equal(t, "tester@example.com", NormalizeEmail(" Tester@Example.com "))
+}
@@ -0,0 +1,15 @@
+package user
+
+import (
+	"errors"
+)
+
+// Errors
+var (
+	ErrNotFound      = errors.New("user: not found")
+	ErrInvalidToken  = errors.New("user: invalid token")
+	ErrTokenExpired  = errors.New("user: token expired")
+	ErrEmailVerified = errors.New("user: email already verified")
+
+	ErrEmailNotAvailable = errors.New("user: email verified by other user")
+)
@@ -0,0 +1,346 @@
+package user
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Export is the user's personal data archive
+type Export struct {
+	Profile  *ExportProfile   `json:"profile"`
+	Sessions []*ExportSession `json:"sessions"`
+	Reviews  []*ExportReview  `json:"reviews"`
+
+	OwnedShops []*ExportOwnedShop `json:"ownedShops"`
+	ShopClaims []*ExportShopClaim `json:"shopClaims"`
+	Identities []*ExportIdentity  `json:"identities"`
+	APIKeys    []*ExportAPIKey    `json:"apiKeys"`
+}
+
+// ExportProfile type
+type ExportProfile struct {
+	ID          int64     `json:"id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl"`
+	Bio         string    `json:"bio"`
+	Roles       []string  `json:"roles"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	// two-factor secret and recovery codes are not exported
+	TwoFactorEnabled       bool `json:"twoFactorEnabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// ExportSession type
+type ExportSession struct {
+	// Token is masked, only first characters are exported
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportReview type
+type ExportReview struct {
+	ID        int64     `json:"id"`
+	ShopID    int64     `json:"shopId"`
+	ShopName  string    `json:"shopName"`
+	Rating    int       `json:"rating"`
+	Comment   string    `json:"comment"`
+	Photos    []string  `json:"photos"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportOwnedShop type
+type ExportOwnedShop struct {
+	ShopID    int64     `json:"shopId"`
+	ShopName  string    `json:"shopName"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportShopClaim type
+type ExportShopClaim struct {
+	ID           int64      `json:"id"`
+	ShopID       int64      `json:"shopId"`
+	ShopName     string     `json:"shopName"`
+	Evidence     string     `json:"evidence"`
+	Status       string     `json:"status"`
+	RejectReason string     `json:"rejectReason"`
+	ReviewedAt   *time.Time `json:"reviewedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// ExportIdentity is the linked social account
+type ExportIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportAPIKey is the api key metadata, key is not stored
+type ExportAPIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return token[:4] + "****"
+}
+
+// Export collects all user's personal data
+func (svc *User) Export(ctx context.Context, userID int64) (*Export, error) {
+	var (
+		p   ExportProfile
+		exp Export
+	)
+	err := svc.db.QueryRowContext(ctx, `
+		select
+			id, username, coalesce(email, ''), display_name, avatar_url, bio, created_at,
+			array(select role from user_roles where user_id = users.id order by role),
+			totp_enabled,
+			(select count(*) from recovery_codes where user_id = users.id and used_at is null)
+		from users
+		where id = $1 and deleted_at is null
+	`, userID).Scan(
+		&p.ID, &p.Username, &p.Email, &p.DisplayName, &p.AvatarURL, &p.Bio, &p.CreatedAt,
+		pq.Array(&p.Roles),
+		&p.TwoFactorEnabled,
+		&p.RecoveryCodesRemaining,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	exp.Profile = &p
+
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			id, created_at
+		from auth_tokens
+		where user_id = $1
+		order by created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.Sessions = []*ExportSession{}
+	for rows.Next() {
+		var x ExportSession
+		err = rows.Scan(&x.Token, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		x.Token = maskToken(x.Token)
+
+		exp.Sessions = append(exp.Sessions, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = svc.db.QueryContext(ctx, `
+		select
+			reviews.id, reviews.shop_id, shops.name, reviews.rating,
+			reviews.comment, reviews.photos, reviews.created_at
+		from reviews
+		left join shops on reviews.shop_id = shops.id
+		where reviews.user_id = $1
+		order by reviews.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.Reviews = []*ExportReview{}
+	for rows.Next() {
+		var x ExportReview
+		err = rows.Scan(
+			&x.ID, &x.ShopID, &x.ShopName, &x.Rating,
+			&x.Comment, pq.Array(&x.Photos), &x.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.Reviews = append(exp.Reviews, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = svc.db.QueryContext(ctx, `
+		select
+			shop_owners.shop_id, shops.name, shop_owners.created_at
+		from shop_owners
+		left join shops on shop_owners.shop_id = shops.id
+		where shop_owners.user_id = $1
+		order by shop_owners.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.OwnedShops = []*ExportOwnedShop{}
+	for rows.Next() {
+		var x ExportOwnedShop
+		err = rows.Scan(&x.ShopID, &x.ShopName, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.OwnedShops = append(exp.OwnedShops, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = svc.db.QueryContext(ctx, `
+		select
+			shop_claims.id, shop_claims.shop_id, shops.name, shop_claims.evidence,
+			shop_claims.status, shop_claims.reject_reason, shop_claims.reviewed_at,
+			shop_claims.created_at
+		from shop_claims
+		left join shops on shop_claims.shop_id = shops.id
+		where shop_claims.user_id = $1
+		order by shop_claims.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.ShopClaims = []*ExportShopClaim{}
+	for rows.Next() {
+		var x ExportShopClaim
+		err = rows.Scan(
+			&x.ID, &x.ShopID, &x.ShopName, &x.Evidence,
+			&x.Status, &x.RejectReason, &x.ReviewedAt,
+			&x.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.ShopClaims = append(exp.ShopClaims, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = svc.db.QueryContext(ctx, `
+		select
+			provider, subject, email, created_at
+		from user_identities
+		where user_id = $1
+		order by created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.Identities = []*ExportIdentity{}
+	for rows.Next() {
+		var x ExportIdentity
+		err = rows.Scan(&x.Provider, &x.Subject, &x.Email, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.Identities = append(exp.Identities, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = svc.db.QueryContext(ctx, `
+		select
+			id, name, prefix, scopes, expires_at, last_used_at, created_at
+		from api_keys
+		where user_id = $1
+		order by created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exp.APIKeys = []*ExportAPIKey{}
+	for rows.Next() {
+		var x ExportAPIKey
+		err = rows.Scan(
+			&x.ID, &x.Name, &x.Prefix, pq.Array(&x.Scopes),
+			&x.ExpiresAt, &x.LastUsedAt, &x.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.APIKeys = append(exp.APIKeys, &x)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &exp, nil
+}
+
+// WriteZip writes export as zip archive contains one json file per section
+func (exp *Export) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		Name string
+		Data interface{}
+	}{
+		{"profile.json", exp.Profile},
+		{"sessions.json", exp.Sessions},
+		{"reviews.json", exp.Reviews},
+		{"owned_shops.json", exp.OwnedShops},
+		{"shop_claims.json", exp.ShopClaims},
+		{"identities.json", exp.Identities},
+		{"api_keys.json", exp.APIKeys},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(f.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
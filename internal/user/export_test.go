@@ -0,0 +1,93 @@
+package user
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport_WriteZip(t *testing.T) {
+	exp := Export{
+		Profile: &ExportProfile{ID: 1, Username: "tester", Roles: []string{}, CreatedAt: time.Now()},
+		Sessions: []*ExportSession{
+			{Token: maskToken("7OJPmLAqocVqBE8k6ud2Zg"), CreatedAt: time.Now()},
+		},
+		Reviews: []*ExportReview{},
+		OwnedShops: []*ExportOwnedShop{
+			{ShopID: 2, ShopName: "Moonstore", CreatedAt: time.Now()},
+		},
+		ShopClaims: []*ExportShopClaim{},
+	}
+
+	var buf bytes.Buffer
+	err := exp.WriteZip(&buf)
+	assert.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{
+		"profile.json", "sessions.json", "reviews.json",
+		"owned_shops.json", "shop_claims.json", "identities.json",
+		"api_keys.json",
+	}, names)
+
+	rc, err := zr.File[1].Open()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rc.Close()
+
+	var sessions []*ExportSession
+	assert.NoError(t, json.NewDecoder(rc).Decode(&sessions))
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "7OJP****", sessions[0].Token)
+	}
+}
+
+func TestExport_WriteZip_Sections(t *testing.T) {
+	exp := Export{
+		Profile:    &ExportProfile{ID: 1, TwoFactorEnabled: true, RecoveryCodesRemaining: 8},
+		OwnedShops: []*ExportOwnedShop{{ShopID: 2, ShopName: "Moonstore"}},
+		ShopClaims: []*ExportShopClaim{{ID: 3, ShopID: 2, Status: "approved"}},
+		Identities: []*ExportIdentity{{Provider: "google", Subject: "1234"}},
+		APIKeys:    []*ExportAPIKey{{ID: 4, Name: "ci", Prefix: "wk_abcd", Scopes: []string{}}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, exp.WriteZip(&buf))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+
+	assert.Contains(t, files["profile.json"], `"twoFactorEnabled": true`)
+	assert.Contains(t, files["profile.json"], `"recoveryCodesRemaining": 8`)
+	assert.Contains(t, files["owned_shops.json"], `"shopName": "Moonstore"`)
+	assert.Contains(t, files["shop_claims.json"], `"status": "approved"`)
+	assert.Contains(t, files["identities.json"], `"provider": "google"`)
+	assert.Contains(t, files["api_keys.json"], `"prefix": "wk_abcd"`)
+	assert.NotContains(t, files["api_keys.json"], "hash")
+}
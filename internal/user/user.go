@@ -0,0 +1,225 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/mailer"
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+// User service
+type User struct {
+	db             *sql.DB
+	mailer         mailer.Mailer
+	emailSecret    []byte
+	verifyEmailURL string
+	accessTokenTTL time.Duration
+}
+
+// Config is user service's config
+type Config struct {
+	Mailer mailer.Mailer
+
+	// EmailSecret signs email verification tokens
+	EmailSecret []byte
+
+	// VerifyEmailURL is the front end's page which receives token query
+	VerifyEmailURL string
+
+	// AccessTokenTTL is signed access token's lifetime,
+	// sessions of deleted user stay revoked for this duration
+	AccessTokenTTL time.Duration
+}
+
+// New creates new user service
+func New(db *sql.DB, config Config) *User {
+	return &User{
+		db:             db,
+		mailer:         config.Mailer,
+		emailSecret:    config.EmailSecret,
+		verifyEmailURL: config.VerifyEmailURL,
+		accessTokenTTL: config.AccessTokenTTL,
+	}
+}
+
+// Profile entity
+type Profile struct {
+	ID            int64
+	Username      string
+	Email         string
+	EmailVerified bool
+	DisplayName   string
+	AvatarURL     string
+	Bio           string
+	CreatedAt     time.Time
+}
+
+// GetProfile retrieves user's own profile
+func (svc *User) GetProfile(ctx context.Context, userID int64) (*Profile, error) {
+	var p Profile
+	err := svc.db.QueryRowContext(ctx, `
+		select
+			id, username, coalesce(email, ''), email_verified_at is not null,
+			display_name, avatar_url, bio, created_at
+		from users
+		where id = $1 and deleted_at is null
+	`, userID).Scan(
+		&p.ID, &p.Username, &p.Email, &p.EmailVerified,
+		&p.DisplayName, &p.AvatarURL, &p.Bio, &p.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateProfile type, nil fields are left unchanged
+type UpdateProfile struct {
+	DisplayName *string
+	AvatarURL   *string
+	Bio         *string
+}
+
+func (p *UpdateProfile) validate() error {
+	if p.DisplayName != nil {
+		*p.DisplayName = strings.TrimSpace(*p.DisplayName)
+		if utf8.RuneCountInString(*p.DisplayName) > 50 {
+			return validate.NewError("displayName", "too long")
+		}
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" {
+		if len(*p.AvatarURL) > 200 {
+			return validate.NewError("avatarUrl", "too long")
+		}
+		if !govalidator.IsURL(*p.AvatarURL) {
+			return validate.NewError("avatarUrl", "invalid")
+		}
+	}
+	if p.Bio != nil {
+		*p.Bio = strings.TrimSpace(*p.Bio)
+		if utf8.RuneCountInString(*p.Bio) > 500 {
+			return validate.NewError("bio", "too long")
+		}
+	}
+	return nil
+}
+
+// UpdateProfile updates user's own profile
+func (svc *User) UpdateProfile(ctx context.Context, userID int64, profile *UpdateProfile) error {
+	err := profile.validate()
+	if err != nil {
+		return err
+	}
+
+	res, err := svc.db.ExecContext(ctx, `
+		update users
+		set
+			display_name = coalesce($2, display_name),
+			avatar_url = coalesce($3, avatar_url),
+			bio = coalesce($4, bio)
+		where id = $1 and deleted_at is null
+	`, userID, profile.DisplayName, profile.AvatarURL, profile.Bio)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Review entity
+type Review struct {
+	ID        int64
+	ShopID    int64
+	ShopName  string
+	Rating    int
+	Comment   string
+	Photos    []string
+	CreatedAt time.Time
+}
+
+// PublicProfile is the user's profile visible to everyone
+type PublicProfile struct {
+	Username      string
+	DisplayName   string
+	AvatarURL     string
+	Bio           string
+	ReviewCount   int
+	RecentReviews []*Review
+	CreatedAt     time.Time
+}
+
+// GetPublicProfile retrieves user's public profile with recent reviews
+func (svc *User) GetPublicProfile(ctx context.Context, username string) (*PublicProfile, error) {
+	username = auth.NormalizeUsername(username)
+	if username == "" {
+		return nil, ErrNotFound
+	}
+
+	var (
+		userID int64
+		p      PublicProfile
+	)
+	err := svc.db.QueryRowContext(ctx, `
+		select
+			id, username, display_name, avatar_url, bio, created_at,
+			(select count(*) from reviews where user_id = users.id)
+		from users
+		where username = $1 and deleted_at is null
+	`, username).Scan(
+		&userID, &p.Username, &p.DisplayName, &p.AvatarURL, &p.Bio, &p.CreatedAt,
+		&p.ReviewCount,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := svc.db.QueryContext(ctx, `
+		select
+			reviews.id, reviews.shop_id, shops.name, reviews.rating,
+			reviews.comment, reviews.photos, reviews.created_at
+		from reviews
+		left join shops on reviews.shop_id = shops.id
+		where reviews.user_id = $1
+		order by reviews.created_at desc
+		limit 10
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x Review
+		err = rows.Scan(
+			&x.ID, &x.ShopID, &x.ShopName, &x.Rating,
+			&x.Comment, pq.Array(&x.Photos), &x.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		p.RecentReviews = append(p.RecentReviews, &x)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/acoshift/wongnok/internal/validate"
+)
+
+func TestUpdateProfile_validate(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	cases := []struct {
+		Name    string
+		Profile UpdateProfile
+		Field   string
+	}{
+		{"Empty", UpdateProfile{}, ""},
+		{"Valid", UpdateProfile{DisplayName: str("Tester"), AvatarURL: str("https://example.com/a.png"), Bio: str("hello")}, ""},
+		{"Remove avatar", UpdateProfile{AvatarURL: str("")}, ""},
+		{"Display name too long", UpdateProfile{DisplayName: str(strings.Repeat("ก", 51))}, "displayName"},
+		{"Invalid avatar", UpdateProfile{AvatarURL: str("not url")}, "avatarUrl"},
+		{"Bio too long", UpdateProfile{Bio: str(strings.Repeat("a", 501))}, "bio"},
+	}
+	for _, tC := range cases {
+		t.Run(tC.Name, func(t *testing.T) {
+			err := tC.Profile.validate()
+			if tC.Field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &validate.Error{}, err) {
+				assert.Equal(t, tC.Field, err.(*validate.Error).Field)
+			}
+		})
+	}
+
+	t.Run("Trim", func(t *testing.T) {
+		p := UpdateProfile{DisplayName: str("  Tester ")}
+		assert.NoError(t, p.validate())
+		assert.Equal(t, "Tester", *p.DisplayName)
+	})
+}
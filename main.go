@@ -2,12 +2,18 @@ package main // import "github.com/acoshift/wongnok"
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,7 +21,11 @@ import (
 
 	"github.com/acoshift/wongnok/internal/api"
 	"github.com/acoshift/wongnok/internal/auth"
+	"github.com/acoshift/wongnok/internal/idempotency"
+	"github.com/acoshift/wongnok/internal/mailer"
 	"github.com/acoshift/wongnok/internal/management"
+	"github.com/acoshift/wongnok/internal/ratelimit"
+	"github.com/acoshift/wongnok/internal/user"
 )
 
 func main() {
@@ -37,11 +47,77 @@ func main() {
 		log.Println(err)
 	}
 
+	signer := tokenSigner()
+
+	userService := user.New(db, user.Config{
+		Mailer:         newMailer(),
+		EmailSecret:    emailSecret(),
+		VerifyEmailURL: os.Getenv("VERIFY_EMAIL_URL"),
+		AccessTokenTTL: signer.TTL(),
+	})
+
+	// hard-delete users after 30 days grace period
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	go userService.RunPurgeJob(jobCtx, time.Hour, 30*24*time.Hour)
+
+	authService := auth.New(db, auth.Config{
+		Providers:                oauthProviders(),
+		RequireTwoFactorForAdmin: os.Getenv("REQUIRE_ADMIN_2FA") == "true",
+		Signer:                   signer,
+		PasswordHasher:           passwordHasher(),
+		PasswordPolicy:           passwordPolicy(),
+		CacheSize:                tokenCacheSize(),
+	})
+	go authService.RunRevocationSync(jobCtx, 10*time.Second)
+	go authService.RunCacheInvalidation(jobCtx, dataSource)
+
+	// replicas share rate limits through postgres
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		s := ratelimit.NewPostgresStore(db)
+		go s.RunPurgeJob(jobCtx, 10*time.Minute, time.Hour)
+		rateLimitStore = s
+	}
+
+	rateLimits := []api.RateLimit{
+		{Prefix: "/", Rate: ratelimit.Rate{Limit: 300, Per: time.Minute}},
+		{Prefix: "/auth/", Rate: ratelimit.Rate{Limit: 10, Per: time.Minute}},
+		{Prefix: "/management/", Rate: ratelimit.Rate{Limit: 60, Per: time.Minute}},
+	}
+	err = api.ValidateRateLimits(rateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var idempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+	if os.Getenv("IDEMPOTENCY_STORE") == "postgres" {
+		s := idempotency.NewPostgresStore(db)
+		go s.RunPurgeJob(jobCtx, 10*time.Minute)
+		idempotencyStore = s
+	}
+
 	server := http.Server{
 		Addr: ":8080",
 		Handler: api.API{
-			Auth:       auth.New(db),
+			Auth:       authService,
 			Management: management.New(db),
+			User:       userService,
+
+			InsecureCookie: os.Getenv("INSECURE_COOKIE") == "true",
+			RateLimitStore: rateLimitStore,
+			RateLimits:     rateLimits,
+			TrustedProxies: trustedProxies(),
+			CORS:           corsConfig(),
+
+			// let older clients send fields removed from the api
+			AllowUnknownFields: os.Getenv("ALLOW_UNKNOWN_FIELDS") == "true",
+
+			IdempotencyStore: idempotencyStore,
+			IdempotencyTTL:   idempotencyTTL(),
+
+			LegacyDeprecation: legacyAPIDate("LEGACY_API_DEPRECATION"),
+			LegacySunset:      legacyAPIDate("LEGACY_API_SUNSET"),
 		}.Handler(),
 	}
 
@@ -66,6 +142,8 @@ func main() {
 		os.Exit(0)
 	}()
 
+	cancelJobs()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 	err = server.Shutdown(ctx)
@@ -74,3 +152,229 @@ func main() {
 		return
 	}
 }
+
+// oauthProviders configures external identity providers from env,
+// provider's redirect url is OAUTH_REDIRECT_URL + "/" + provider's name
+func oauthProviders() []auth.Provider {
+	redirectURL := os.Getenv("OAUTH_REDIRECT_URL")
+
+	var providers []auth.Provider
+	for _, x := range []struct {
+		Env string
+		New func(clientID, clientSecret, redirectURL string) *auth.OAuth2Provider
+	}{
+		{"GOOGLE", auth.GoogleProvider},
+		{"FACEBOOK", auth.FacebookProvider},
+		{"LINE", auth.LINEProvider},
+	} {
+		clientID := os.Getenv(x.Env + "_CLIENT_ID")
+		if clientID == "" {
+			continue
+		}
+		p := x.New(clientID, os.Getenv(x.Env+"_CLIENT_SECRET"), "")
+		p.RedirectURL = redirectURL + "/" + p.Name()
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// newMailer sends email through SMTP_ADDR if set,
+// otherwise writes emails into MAIL_DIR for development
+func newMailer() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@wongnok.local"
+	}
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return &mailer.SMTPMailer{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "wongnok-mail")
+	}
+	log.Printf("SMTP_ADDR not set, writing emails to %s\n", dir)
+	return &mailer.FileMailer{Dir: dir, From: from}
+}
+
+// emailSecret loads EMAIL_SECRET, random secret is used if not set
+// which invalidates verification links after restart
+func emailSecret() []byte {
+	if s := os.Getenv("EMAIL_SECRET"); s != "" {
+		return []byte(s)
+	}
+	log.Println("EMAIL_SECRET not set, using random secret")
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+// passwordHasher loads PASSWORD_HASH (bcrypt or argon2id) and BCRYPT_COST
+func passwordHasher() *auth.PasswordHasher {
+	h := auth.PasswordHasher{Algorithm: os.Getenv("PASSWORD_HASH")}
+	if s := os.Getenv("BCRYPT_COST"); s != "" {
+		cost, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("invalid BCRYPT_COST; %v", err)
+		}
+		h.BcryptCost = cost
+	}
+	return &h
+}
+
+// passwordPolicy requires PASSWORD_MIN_SCORE, default 2,
+// and checks BREACHED_PASSWORDS_FILE if set
+func passwordPolicy() *auth.PasswordPolicy {
+	p := auth.PasswordPolicy{MinScore: 2}
+	if s := os.Getenv("PASSWORD_MIN_SCORE"); s != "" {
+		score, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("invalid PASSWORD_MIN_SCORE; %v", err)
+		}
+		p.MinScore = score
+	}
+	if name := os.Getenv("BREACHED_PASSWORDS_FILE"); name != "" {
+		l, err := auth.LoadHashList(name)
+		if err != nil {
+			log.Fatalf("can not load breached passwords; %v", err)
+		}
+		p.Breached = l
+	}
+	return &p
+}
+
+// trustedProxies loads TRUSTED_PROXIES, comma separated CIDRs or IPs
+func trustedProxies() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Fatalf("invalid TRUSTED_PROXIES; %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// corsConfig loads CORS_ALLOWED_ORIGINS, comma separated origins
+// which may use wildcard subdomain, ex. https://*.wongnok.com
+func corsConfig() *api.CORS {
+	var origins []string
+	for _, s := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			origins = append(origins, s)
+		}
+	}
+	if len(origins) == 0 {
+		return nil
+	}
+
+	return &api.CORS{
+		AllowedOrigins:   origins,
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+// tokenCacheSize loads TOKEN_CACHE_SIZE, default 10000 sessions, 0 disables cache
+func tokenCacheSize() int {
+	s := os.Getenv("TOKEN_CACHE_SIZE")
+	if s == "" {
+		return 10000
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("invalid TOKEN_CACHE_SIZE; %v", err)
+	}
+	return n
+}
+
+// idempotencyTTL reads IDEMPOTENCY_TTL duration, ex. "24h"
+func idempotencyTTL() time.Duration {
+	s := os.Getenv("IDEMPOTENCY_TTL")
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("invalid IDEMPOTENCY_TTL; %v", err)
+	}
+	return d
+}
+
+// legacyAPIDate reads date of unversioned routes' lifecycle from env,
+// ex. LEGACY_API_SUNSET=2027-04-30. Empty leaves the date unset
+func legacyAPIDate(name string) time.Time {
+	s := os.Getenv(name)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		log.Fatalf("invalid %s; %v", name, err)
+	}
+	return t
+}
+
+// tokenSigner loads access token keys from ACCESS_TOKEN_KEYS,
+// comma separated list of "kid:alg:base64-secret" where first key signs new tokens,
+// EdDSA secret is 32 bytes seed. Access tokens are disabled if not set
+func tokenSigner() *auth.Signer {
+	env := os.Getenv("ACCESS_TOKEN_KEYS")
+	if env == "" {
+		return nil
+	}
+
+	var keys []*auth.Key
+	for _, s := range strings.Split(env, ",") {
+		parts := strings.SplitN(strings.TrimSpace(s), ":", 3)
+		if len(parts) != 3 {
+			log.Fatal("invalid ACCESS_TOKEN_KEYS")
+		}
+		secret, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			log.Fatalf("invalid secret for access token key %s; %v", parts[0], err)
+		}
+
+		switch parts[1] {
+		case auth.AlgHS256:
+			keys = append(keys, auth.NewHS256Key(parts[0], secret))
+		case auth.AlgEdDSA:
+			key, err := auth.NewEdDSAKey(parts[0], secret)
+			if err != nil {
+				log.Fatalf("invalid access token key %s; %v", parts[0], err)
+			}
+			keys = append(keys, key)
+		default:
+			log.Fatalf("unknown algorithm %s for access token key %s", parts[1], parts[0])
+		}
+	}
+
+	ttl := 5 * time.Minute
+	if s := os.Getenv("ACCESS_TOKEN_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid ACCESS_TOKEN_TTL; %v", err)
+		}
+		ttl = d
+	}
+	return auth.NewSigner(ttl, keys[0], keys[1:]...)
+}
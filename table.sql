@@ -2,18 +2,158 @@ create table users (
 	id bigserial,
 	username varchar not null,
 	password varchar not null,
-	is_admin boolean not null default false,
+	email varchar,
+	email_verified_at timestamp,
+	display_name varchar not null default '',
+	avatar_url varchar not null default '',
+	bio varchar not null default '',
+	totp_secret varchar not null default '',
+	totp_enabled boolean not null default false,
+	totp_last_counter bigint not null default 0,
 	created_at timestamp not null default now(),
+	deleted_at timestamp,
 	primary key (id)
 );
 create unique index users_username_idx on users (username);
+-- email is unique only when verified, unverified email can not block its owner
+create unique index users_email_idx on users (email) where email_verified_at is not null;
+
+create table roles (
+	name varchar,
+	description varchar not null default '',
+	created_at timestamp not null default now(),
+	primary key (name)
+);
+
+create table role_permissions (
+	role varchar not null,
+	permission varchar not null,
+	primary key (role, permission),
+	foreign key (role) references roles (name) on delete cascade
+);
+
+create table user_roles (
+	user_id bigint not null,
+	role varchar not null,
+	created_at timestamp not null default now(),
+	primary key (user_id, role),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (role) references roles (name) on delete cascade
+);
+
+insert into roles (name, description) values
+	('admin', 'Administrator'),
+	('moderator', 'Review moderator');
+insert into role_permissions (role, permission) values
+	('admin', 'shop.create'),
+	('admin', 'shop.manage'),
+	('admin', 'taxonomy.manage'),
+	('admin', 'claim.review'),
+	('admin', 'review.moderate'),
+	('admin', 'user.manage'),
+	('moderator', 'review.moderate');
+
+-- migrate from users.is_admin, skipped when users never had the column
+do $$
+begin
+	if exists (
+		select 1
+		from information_schema.columns
+		where table_name = 'users' and column_name = 'is_admin'
+	) then
+		insert into user_roles (user_id, role)
+		select id, 'admin' from users where is_admin
+		on conflict do nothing;
+		alter table users drop column is_admin;
+	end if;
+end $$;
 
 create table auth_tokens (
 	id varchar,
 	user_id bigint not null,
 	created_at timestamp not null default now(),
 	primary key (id),
-	foreign key (user_id) references users (id)
+	foreign key (user_id) references users (id) on delete cascade
+);
+
+-- session id is sha256 of token, see sessionID,
+-- backfill existing tokens before requiring it
+alter table auth_tokens add column if not exists session_id varchar;
+update auth_tokens
+set session_id = encode(sha256(convert_to(id, 'UTF8')), 'hex')
+where session_id is null;
+alter table auth_tokens alter column session_id set not null;
+create unique index auth_tokens_session_id_idx on auth_tokens (session_id);
+
+create table api_keys (
+	id bigserial,
+	user_id bigint not null,
+	name varchar not null,
+	key_hash varchar not null,
+	prefix varchar not null,
+	scopes varchar[] not null default '{}',
+	expires_at timestamp,
+	last_used_at timestamp,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (user_id) references users (id) on delete cascade
+);
+create unique index api_keys_key_hash_idx on api_keys (key_hash);
+create index api_keys_user_id_idx on api_keys (user_id);
+
+-- sessions signed out while signed access tokens may still be alive,
+-- rows expire after access token's ttl
+create table revoked_sessions (
+	id varchar,
+	expires_at timestamp not null,
+	primary key (id)
+);
+
+create table recovery_codes (
+	user_id bigint not null,
+	code_hash varchar not null,
+	used_at timestamp,
+	created_at timestamp not null default now(),
+	primary key (user_id, code_hash),
+	foreign key (user_id) references users (id) on delete cascade
+);
+
+create table auth_challenges (
+	id varchar,
+	user_id bigint not null,
+	attempts int not null default 0,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (user_id) references users (id) on delete cascade
+);
+
+create table two_factor_failures (
+	user_id bigint not null,
+	created_at timestamp not null default now(),
+	foreign key (user_id) references users (id) on delete cascade
+);
+create index two_factor_failures_user_id_idx on two_factor_failures (user_id, created_at);
+
+create table user_identities (
+	provider varchar not null,
+	subject varchar not null,
+	user_id bigint not null,
+	email varchar not null default '',
+	created_at timestamp not null default now(),
+	primary key (provider, subject),
+	foreign key (user_id) references users (id) on delete cascade
+);
+create index user_identities_user_id_idx on user_identities (user_id);
+
+create table oauth_states (
+	id varchar,
+	provider varchar not null,
+	code_verifier varchar not null,
+	nonce_hash varchar not null,
+	user_id bigint,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (user_id) references users (id) on delete cascade
 );
 
 create table shops (
@@ -21,19 +161,177 @@ create table shops (
 	name varchar not null,
 	description varchar not null,
 	photos varchar[] not null,
+	timezone varchar not null default 'Asia/Bangkok',
+	opening_hours jsonb,
 	created_at timestamp not null default now(),
+	updated_at timestamp not null default now(),
+	version bigint not null default 1,
 	primary key (id)
 );
 
+-- clock_minutes converts "15:04" to minutes since midnight, "24:00" is 1440
+create function clock_minutes(s varchar) returns int as $$
+	select split_part(s, ':', 1)::int * 60 + split_part(s, ':', 2)::int
+$$ language sql immutable;
+
+-- shop_intervals returns opening intervals which start on the date,
+-- special day overrides weekly schedule, see OpeningHours.intervalsOn
+create function shop_intervals(hours jsonb, d date) returns setof jsonb as $$
+	select jsonb_array_elements(coalesce(
+		(
+			select case
+				when coalesce((sp->>'closed')::boolean, false) then '[]'::jsonb
+				else coalesce(nullif(sp->'intervals', 'null'::jsonb), '[]'::jsonb)
+			end
+			from jsonb_array_elements(case jsonb_typeof(hours->'specials')
+				when 'array' then hours->'specials'
+				else '[]'::jsonb
+			end) as sp
+			where sp->>'date' = to_char(d, 'YYYY-MM-DD')
+		),
+		nullif(hours->'weekly'->(extract(dow from d)::int), 'null'::jsonb),
+		'[]'::jsonb
+	))
+$$ language sql immutable;
+
+-- shop_open_at reports whether shop is open at t, see Shop.IsOpenAt
+create function shop_open_at(hours jsonb, timezone varchar, t timestamptz) returns boolean as $$
+	select hours is not null and exists (
+		select 1
+		from
+			(
+				select
+					local::date as today,
+					extract(hour from local)::int * 60 + extract(minute from local)::int as now
+				from (select t at time zone coalesce(nullif(timezone, ''), 'Asia/Bangkok') as local) as x
+			) as l,
+			lateral (
+				select iv, false as yesterday from shop_intervals(hours, l.today) as iv
+				union all
+				select iv, true from shop_intervals(hours, l.today - 1) as iv
+			) as x
+		where case
+			-- overnight interval closes on the next day
+			when clock_minutes(x.iv->>'close') <= clock_minutes(x.iv->>'open') then
+				case
+					when x.yesterday then l.now < clock_minutes(x.iv->>'close')
+					else l.now >= clock_minutes(x.iv->>'open')
+				end
+			else not x.yesterday
+				and l.now >= clock_minutes(x.iv->>'open')
+				and l.now < clock_minutes(x.iv->>'close')
+		end
+	)
+$$ language sql stable;
+
 create table reviews (
 	id bigserial,
 	shop_id bigint not null,
-	user_id bigint not null,
+	user_id bigint, -- null when user was deleted
 	rating smallint not null,
 	comment varchar not null,
 	photos varchar[] not null,
+	reply varchar not null default '',
+	replied_at timestamp,
 	created_at timestamp not null default now(),
 	primary key (id),
 	foreign key (shop_id) references shops (id),
-	foreign key (user_id) references users (id)
+	foreign key (user_id) references users (id) on delete set null
+);
+create index reviews_user_id_idx on reviews (user_id, created_at desc);
+
+create table taxonomy_terms (
+	id bigserial,
+	kind varchar not null,
+	slug varchar not null,
+	name varchar not null,
+	created_at timestamp not null default now(),
+	primary key (id)
+);
+create unique index taxonomy_terms_kind_slug_idx on taxonomy_terms (kind, slug);
+
+create table shop_terms (
+	shop_id bigint not null,
+	term_id bigint not null,
+	primary key (shop_id, term_id),
+	foreign key (shop_id) references shops (id) on delete cascade,
+	foreign key (term_id) references taxonomy_terms (id) on delete cascade
+);
+create index shop_terms_term_id_idx on shop_terms (term_id);
+
+create table menu_sections (
+	id bigserial,
+	shop_id bigint not null,
+	name varchar not null,
+	position int not null,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (shop_id) references shops (id) on delete cascade
+);
+create index menu_sections_shop_id_idx on menu_sections (shop_id, position);
+
+create table menu_items (
+	id bigserial,
+	section_id bigint not null,
+	shop_id bigint not null,
+	name varchar not null,
+	description varchar not null,
+	price bigint not null,
+	photos varchar[] not null,
+	available boolean not null default true,
+	dietary varchar[] not null,
+	position int not null,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (section_id) references menu_sections (id) on delete cascade,
+	foreign key (shop_id) references shops (id) on delete cascade
+);
+create index menu_items_section_id_idx on menu_items (section_id, position);
+
+create table shop_claims (
+	id bigserial,
+	shop_id bigint not null,
+	user_id bigint not null,
+	evidence varchar not null,
+	status varchar not null,
+	reject_reason varchar not null default '',
+	reviewed_by bigint,
+	reviewed_at timestamp,
+	created_at timestamp not null default now(),
+	primary key (id),
+	foreign key (shop_id) references shops (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (reviewed_by) references users (id) on delete set null
+);
+create unique index shop_claims_pending_idx on shop_claims (shop_id, user_id) where status = 'pending';
+create index shop_claims_status_idx on shop_claims (status, id);
+
+create table shop_owners (
+	shop_id bigint not null,
+	user_id bigint not null,
+	created_at timestamp not null default now(),
+	primary key (shop_id, user_id),
+	foreign key (shop_id) references shops (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade
+);
+create index shop_owners_user_id_idx on shop_owners (user_id);
+
+create table rate_limits (
+	key varchar,
+	tokens double precision not null,
+	updated_at timestamp not null,
+	primary key (key)
+);
+create index rate_limits_updated_at_idx on rate_limits (updated_at);
+
+create table idempotency_keys (
+	key varchar,
+	fingerprint varchar not null,
+	status_code int,
+	header jsonb,
+	body bytea,
+	locked_at timestamp not null,
+	expires_at timestamp not null,
+	primary key (key)
 );
+create index idempotency_keys_expires_at_idx on idempotency_keys (expires_at);